@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func (app *application) listBookTypesHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := app.repos.BookTypeRepo.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"types": types}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBookTypeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Slug     string `json:"slug"`
+		Name     string `json:"name"`
+		ParentID int32  `json:"parent_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	def := &data.BookTypeDefinition{
+		Slug:     input.Slug,
+		Name:     input.Name,
+		ParentID: input.ParentID,
+	}
+
+	v := validator.New()
+
+	if def.ValidateBookTypeDefinition(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.BookTypeRepo.Insert(def)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateBookType) {
+			v.AddError("slug", "a book type with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/types/%d", def.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"type": def}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBookTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	def, err := app.repos.BookTypeRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"type": def}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateBookTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	def, err := app.repos.BookTypeRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Slug     *string `json:"slug"`
+		Name     *string `json:"name"`
+		ParentID *int32  `json:"parent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Slug != nil {
+		def.Slug = *input.Slug
+	}
+
+	if input.Name != nil {
+		def.Name = *input.Name
+	}
+
+	if input.ParentID != nil {
+		def.ParentID = *input.ParentID
+	}
+
+	v := validator.New()
+
+	if def.ValidateBookTypeDefinition(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.BookTypeRepo.Update(def)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBookType):
+			v.AddError("slug", "a book type with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"type": def}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteBookTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repos.BookTypeRepo.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrRecordInUse):
+			app.recordInUseResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book type successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshBookTypes periodically reloads the book type taxonomy so that changes
+// made through another instance become visible here. It returns once ctx is
+// cancelled.
+func (app *application) refreshBookTypes(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.repos.BookTypeRepo.Load()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
@@ -6,59 +6,135 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 )
 
 func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Name     string
-		BookType []data.BookType
+		data.BookFilter
 		data.Filters
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Name = app.readString(qs, "name", "")
-
-	input.BookType = Map(app.readCSV(qs, "type", []string{}), data.NewBookTypeFromString)
-	input.BookType = Filter(input.BookType, func(bookType data.BookType) bool {
-		return bookType != 0
-	})
+	input.BookFilter = app.readBookFilter(qs, v)
 
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	input.SortBy = app.readString(qs, "sort", "id")
-	input.SortSafelist = []string{"id", "-id", "name", "-name", "author", "-author", "publisher", "-publisher"}
+	defaultSort := "id"
+	if input.Fuzzy && input.Name != "" {
+		defaultSort = "similarity"
+	}
+
+	input.SortBy = app.readString(qs, "sort", defaultSort)
+	input.SortSafelist = []string{"id", "-id", "name", "-name", "author", "-author", "publisher", "-publisher", "relevance", "similarity", "rating", "-rating"}
+	v.Check(input.SortBy != "relevance" || input.Query != "", "sort", "relevance requires a q parameter")
+	v.Check(input.SortBy != "similarity" || (input.Fuzzy && input.Name != ""), "sort", "similarity requires a name parameter and match=fuzzy")
+
+	if qs.Has("cursor") {
+		cursor, err := data.DecodeCursor(qs.Get("cursor"))
+		if err != nil {
+			v.AddError("cursor", "must be a cursor returned by a previous request")
+		}
+		input.Cursor = cursor
+	}
+
+	facets := app.readCSV(qs, "facets", []string{})
+	for _, field := range facets {
+		v.Check(validator.PermittedValue(field, data.BookFacetFields...), "facets", "must only contain type, author or publisher")
+	}
 
 	if input.ValidateFilters(v); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	books, metadata, err := app.repos.BookRepo.GetAll(input.Name, input.BookType, input.Filters)
+	books, metadata, err := app.repos.BookRepo.GetAll(input.BookFilter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "books": books}, nil)
+	env := envelope{"metadata": metadata, "books": books}
+
+	if len(facets) > 0 {
+		env["facets"], err = app.repos.BookRepo.Facets(input.BookFilter, facets, bookFacetLimit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if len(books) == 0 && input.Name != "" {
+		suggestion, err := app.repos.BookRepo.SuggestName(input.BookFilter)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if suggestion != "" {
+			env["did_you_mean"] = suggestion
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
 
+// bookFacetLimit is the number of top authors and publishers returned when
+// their facets are requested.
+const bookFacetLimit = 10
+
+// readBookFilter reads the query string parameters shared by every endpoint
+// which lists books.
+func (app *application) readBookFilter(qs url.Values, v *validator.Validator) data.BookFilter {
+	var filter data.BookFilter
+
+	filter.Name = app.readString(qs, "name", "")
+	filter.Query = app.readString(qs, "q", "")
+
+	match := app.readString(qs, "match", "exact")
+	v.Check(validator.PermittedValue(match, "exact", "fuzzy"), "match", "must be one of exact or fuzzy")
+	filter.Fuzzy = match == "fuzzy"
+	filter.SimilarityThreshold = app.config.search.fuzzyThreshold
+
+	filter.Types = Map(app.readCSV(qs, "type", []string{}), data.NewBookTypeFromString)
+	filter.Types = Filter(filter.Types, func(bookType data.BookType) bool {
+		return bookType != 0
+	})
+
+	filter.AuthorID = int64(app.readInt(qs, "author_id", 0, v))
+	v.Check(filter.AuthorID >= 0, "author_id", "must be a positive integer")
+
+	filter.PublisherID = int64(app.readInt(qs, "publisher_id", 0, v))
+	v.Check(filter.PublisherID >= 0, "publisher_id", "must be a positive integer")
+
+	filter.MinRating = app.readFloat(qs, "min_rating", 0, v)
+	v.Check(filter.MinRating >= 0 && filter.MinRating <= 5, "min_rating", "must be between 0 and 5")
+
+	return filter
+}
+
 func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Name       string          `json:"name"`
-		Author     string          `json:"author"`
-		Publisher  string          `json:"publisher"`
-		Image      string          `json:"image"`
-		CoverImage string          `json:"cover_image"`
-		Type       []data.BookType `json:"type"`
+		Name        string            `json:"name"`
+		Author      string            `json:"author"`
+		Authors     []data.BookAuthor `json:"authors"`
+		Publisher   string            `json:"publisher"`
+		PublisherID int64             `json:"publisher_id"`
+		ISBN10      string            `json:"isbn10"`
+		ISBN13      string            `json:"isbn13"`
+		Image       string            `json:"image"`
+		CoverImage  string            `json:"cover_image"`
+		Type        []data.BookType   `json:"type"`
+		PageCount   int               `json:"page_count"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -68,14 +144,21 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	book := &data.Book{
-		Name:       input.Name,
-		Author:     input.Author,
-		Publisher:  input.Publisher,
-		Image:      input.Image,
-		CoverImage: input.CoverImage,
-		Type:       input.Type,
+		Name:        input.Name,
+		Author:      input.Author,
+		Authors:     input.Authors,
+		Publisher:   input.Publisher,
+		PublisherID: input.PublisherID,
+		ISBN10:      input.ISBN10,
+		ISBN13:      input.ISBN13,
+		Image:       input.Image,
+		CoverImage:  input.CoverImage,
+		Type:        input.Type,
+		PageCount:   input.PageCount,
 	}
 
+	book.NormalizeISBN()
+
 	v := validator.New()
 
 	if book.ValidateBook(v); !v.Valid() {
@@ -85,7 +168,18 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.repos.BookRepo.Insert(book)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrUnknownAuthor):
+			v.AddError("authors", "must only reference existing authors")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownPublisher):
+			v.AddError("publisher_id", "must reference an existing publisher")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateISBN):
+			app.duplicateISBNResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -116,8 +210,31 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", fmt.Sprintf(`"%d"`, book.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBookByISBNHandler(w http.ResponseWriter, r *http.Request) {
+	book, err := app.repos.BookRepo.GetByISBN(r.PathValue("isbn"))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", fmt.Sprintf(`"%d"`, book.Version))
 
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -140,13 +257,30 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		expected, err := app.readVersionHeader(ifMatch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if expected != book.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
 	var input struct {
-		Name       *string         `json:"name"`
-		Author     *string         `json:"author"`
-		Publisher  *string         `json:"publisher"`
-		Image      *string         `json:"image"`
-		CoverImage *string         `json:"cover_image"`
-		Type       []data.BookType `json:"type"`
+		Name        *string           `json:"name"`
+		Author      *string           `json:"author"`
+		Authors     []data.BookAuthor `json:"authors"`
+		Publisher   *string           `json:"publisher"`
+		PublisherID *int64            `json:"publisher_id"`
+		ISBN10      *string           `json:"isbn10"`
+		ISBN13      *string           `json:"isbn13"`
+		Image       *string           `json:"image"`
+		CoverImage  *string           `json:"cover_image"`
+		Type        []data.BookType   `json:"type"`
+		PageCount   *int              `json:"page_count"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -163,8 +297,39 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		book.Author = *input.Author
 	}
 
+	if input.Authors != nil {
+		book.Authors = input.Authors
+		// The free-text author still holds the names of the old credits, so
+		// it is cleared for saveBookAuthors to rebuild from the new ones.
+		if input.Author == nil {
+			book.Author = ""
+		}
+	}
+
 	if input.Publisher != nil {
 		book.Publisher = *input.Publisher
+		// The old publisher_id may name a different publisher, so the book
+		// is linked again by name unless an id is given too.
+		if input.PublisherID == nil {
+			book.PublisherID = 0
+		}
+	}
+
+	if input.PublisherID != nil {
+		book.PublisherID = *input.PublisherID
+		if input.Publisher == nil {
+			book.Publisher = ""
+		}
+	}
+
+	if input.ISBN10 != nil || input.ISBN13 != nil {
+		book.ISBN10, book.ISBN13 = "", ""
+		if input.ISBN10 != nil {
+			book.ISBN10 = *input.ISBN10
+		}
+		if input.ISBN13 != nil {
+			book.ISBN13 = *input.ISBN13
+		}
 	}
 
 	if input.Image != nil {
@@ -179,6 +344,12 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		book.Type = input.Type
 	}
 
+	if input.PageCount != nil {
+		book.PageCount = *input.PageCount
+	}
+
+	book.NormalizeISBN()
+
 	v := validator.New()
 
 	if book.ValidateBook(v); !v.Valid() {
@@ -188,9 +359,27 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.repos.BookRepo.Update(book)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownAuthor):
+			v.AddError("authors", "must only reference existing authors")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownPublisher):
+			v.AddError("publisher_id", "must reference an existing publisher")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateISBN):
+			app.duplicateISBNResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -201,11 +390,15 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.repos.BookRepo.Delete(id)
 	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-			return
+		case errors.Is(err, data.ErrRecordInUse):
+			app.recordInUseResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportWriteTimeout is granted afresh before every batch, so an export can
+// outlive the server wide WriteTimeout as long as it keeps making progress.
+const exportWriteTimeout = 30 * time.Second
+
+func (app *application) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter := app.readBookFilter(qs, v)
+
+	format := app.readString(qs, "format", "csv")
+	v.Check(validator.PermittedValue(format, "csv", "ndjson"), "format", "must be one of csv or ndjson")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	}
+
+	filename := fmt.Sprintf("bookworm-books-%s.%s", time.Now().UTC().Format("20060102"), format)
+
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	rc := http.NewResponseController(w)
+	buf := bufio.NewWriter(w)
+
+	var write func(books []*data.Book) error
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(buf)
+		header := append([]string{"id"}, importCSVColumns...)
+
+		write = func(books []*data.Book) error {
+			if header != nil {
+				if err := cw.Write(header); err != nil {
+					return err
+				}
+				header = nil
+			}
+
+			for _, book := range books {
+				publisherID := ""
+				if book.PublisherID > 0 {
+					publisherID = strconv.FormatInt(book.PublisherID, 10)
+				}
+
+				pageCount := ""
+				if book.PageCount > 0 {
+					pageCount = strconv.Itoa(book.PageCount)
+				}
+
+				types := Map(book.Type, data.BookType.Slug)
+
+				err := cw.Write([]string{
+					strconv.FormatInt(book.ID, 10),
+					book.Name,
+					book.Author,
+					book.Publisher,
+					publisherID,
+					book.Image,
+					book.CoverImage,
+					strings.Join(types, "|"),
+					book.ISBN10,
+					book.ISBN13,
+					pageCount,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			cw.Flush()
+			return cw.Error()
+		}
+	case "ndjson":
+		enc := json.NewEncoder(buf)
+
+		write = func(books []*data.Book) error {
+			for _, book := range books {
+				if err := enc.Encode(book); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	err := app.repos.BookRepo.Export(r.Context(), filter, func(books []*data.Book) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+			return err
+		}
+
+		if !started {
+			start()
+		}
+
+		if err := write(books); err != nil {
+			return err
+		}
+
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	})
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The status line has already been sent, so the client can only
+		// learn about the failure from the truncated body.
+		app.logError(r, err)
+		return
+	}
+
+	if !started {
+		start()
+		if format == "csv" {
+			write(nil)
+		}
+		buf.Flush()
+	}
+}
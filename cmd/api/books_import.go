@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	importBatchSize = 500
+	importMaxRows   = 10_000
+	importMaxBytes  = 32 << 20
+)
+
+var importCSVColumns = []string{"name", "author", "publisher", "publisher_id", "image", "cover_image", "type", "isbn10", "isbn13", "page_count"}
+
+type importRow struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type importSummary struct {
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Valid   int `json:"valid,omitempty"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// importBookInput mirrors the body accepted by createBookHandler so that an
+// NDJSON line can be pasted from a regular create request.
+type importBookInput struct {
+	Name        string            `json:"name"`
+	Author      string            `json:"author"`
+	Authors     []data.BookAuthor `json:"authors"`
+	Publisher   string            `json:"publisher"`
+	PublisherID int64             `json:"publisher_id"`
+	ISBN10      string            `json:"isbn10"`
+	ISBN13      string            `json:"isbn13"`
+	Image       string            `json:"image"`
+	CoverImage  string            `json:"cover_image"`
+	Type        []data.BookType   `json:"type"`
+	PageCount   int               `json:"page_count"`
+}
+
+func (in importBookInput) book() *data.Book {
+	return &data.Book{
+		Name:        in.Name,
+		Author:      in.Author,
+		Authors:     in.Authors,
+		Publisher:   in.Publisher,
+		PublisherID: in.PublisherID,
+		ISBN10:      in.ISBN10,
+		ISBN13:      in.ISBN13,
+		Image:       in.Image,
+		CoverImage:  in.CoverImage,
+		Type:        in.Type,
+		PageCount:   in.PageCount,
+	}
+}
+
+// importReader yields one book per call and io.EOF once the input is
+// exhausted. Problems with a single row are reported as a rowError so that
+// the remaining rows can still be imported.
+type importReader interface {
+	next() (*data.Book, error)
+}
+
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string {
+	return e.err.Error()
+}
+
+type csvImportReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVImportReader(body io.Reader) (*csvImportReader, error) {
+	r := csv.NewReader(body)
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		// An id column, as written by the export endpoint, is ignored.
+		if name != "id" && !validator.PermittedValue(name, importCSVColumns...) {
+			return nil, fmt.Errorf("body contains unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+
+	r.FieldsPerRecord = len(header)
+
+	return &csvImportReader{r: r, columns: columns}, nil
+}
+
+func (c *csvImportReader) field(record []string, name string) string {
+	i, ok := c.columns[name]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (c *csvImportReader) next() (*data.Book, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, rowError{err: parseErr.Err}
+		}
+		return nil, err
+	}
+
+	book := &data.Book{
+		Name:       c.field(record, "name"),
+		Author:     c.field(record, "author"),
+		Publisher:  c.field(record, "publisher"),
+		Image:      c.field(record, "image"),
+		CoverImage: c.field(record, "cover_image"),
+		ISBN10:     c.field(record, "isbn10"),
+		ISBN13:     c.field(record, "isbn13"),
+	}
+
+	if s := c.field(record, "publisher_id"); s != "" {
+		book.PublisherID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, rowError{err: errors.New("publisher_id must be an integer value")}
+		}
+	}
+
+	if s := c.field(record, "page_count"); s != "" {
+		book.PageCount, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, rowError{err: errors.New("page_count must be an integer value")}
+		}
+	}
+
+	// Multiple types are separated by a pipe because commas already
+	// separate the CSV fields.
+	for _, s := range strings.Split(c.field(record, "type"), "|") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		t := data.NewBookTypeFromString(s)
+		if t == 0 {
+			return nil, rowError{err: fmt.Errorf("unknown book type %q", s)}
+		}
+		book.Type = append(book.Type, t)
+	}
+
+	return book, nil
+}
+
+type ndjsonImportReader struct {
+	s *bufio.Scanner
+}
+
+func newNDJSONImportReader(body io.Reader) *ndjsonImportReader {
+	s := bufio.NewScanner(body)
+	s.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+	return &ndjsonImportReader{s: s}
+}
+
+func (n *ndjsonImportReader) next() (*data.Book, error) {
+	for n.s.Scan() {
+		line := bytes.TrimSpace(n.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input importBookInput
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(&input); err != nil {
+			return nil, rowError{err: err}
+		}
+
+		return input.book(), nil
+	}
+
+	if err := n.s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+func (app *application) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	dryRun := app.readBool(qs, "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+
+	var reader importReader
+
+	switch mediaType {
+	case "text/csv":
+		reader, err = newCSVImportReader(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	case "application/x-ndjson":
+		reader = newNDJSONImportReader(r.Body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	var (
+		report  = make([]importRow, 0)
+		summary importSummary
+		batch   []*data.Book
+		indexes []int
+		// seen holds the ISBNs of earlier valid rows in a dry run, since
+		// nothing is written for CheckBatch to find them.
+		seen = make(map[string]bool)
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		check := app.repos.BookRepo.InsertBatch
+		if dryRun {
+			check = app.repos.BookRepo.CheckBatch
+		}
+
+		results, err := check(batch)
+		if err != nil {
+			return err
+		}
+
+		for i, result := range results {
+			book := batch[i]
+			if dryRun && result == nil && (seen[book.ISBN10] || seen[book.ISBN13]) {
+				result = data.ErrDuplicateISBN
+			}
+
+			row := &report[indexes[i]]
+			switch {
+			case result == nil && dryRun:
+				row.Status = "valid"
+				summary.Valid++
+				if book.ISBN10 != "" {
+					seen[book.ISBN10] = true
+				}
+				if book.ISBN13 != "" {
+					seen[book.ISBN13] = true
+				}
+			case result == nil:
+				row.Status, row.ID = "created", book.ID
+				summary.Created++
+			case errors.Is(result, data.ErrDuplicateISBN):
+				row.Status, row.Error = "skipped", "a book with this ISBN already exists"
+				summary.Skipped++
+			case errors.Is(result, data.ErrUnknownPublisher):
+				row.Status, row.Errors = "failed", map[string]string{"publisher_id": "must reference an existing publisher"}
+				summary.Failed++
+			case errors.Is(result, data.ErrUnknownAuthor):
+				row.Status, row.Errors = "failed", map[string]string{"authors": "must only reference existing authors"}
+				summary.Failed++
+			default:
+				return result
+			}
+		}
+
+		batch, indexes = batch[:0], indexes[:0]
+		return nil
+	}
+
+	// abort stops the import and reports the rows handled so far. Earlier
+	// batches may already have been committed, so the client needs to know
+	// which rows to leave out when it retries. The rows of the pending batch
+	// were not saved.
+	abort := func(status int, message string) {
+		for _, i := range indexes {
+			if report[i].Status == "" {
+				report[i].Status, report[i].Error = "failed", "not imported because the import stopped"
+				summary.Failed++
+			}
+		}
+
+		env := envelope{
+			"error":   message + "; rows after the last one reported were not read",
+			"dry_run": dryRun,
+			"summary": summary,
+			"rows":    report,
+		}
+		if id := app.contextGetRequestID(r); id != "" {
+			env["request_id"] = id
+		}
+
+		err := app.writeJSON(w, status, env, nil)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(status)
+		}
+	}
+
+	abortServerError := func(err error) {
+		app.logError(r, err)
+		abort(http.StatusInternalServerError, "the server encountered a problem and stopped the import")
+	}
+
+	for n := 1; ; n++ {
+		book, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if n > importMaxRows {
+			abort(http.StatusBadRequest, fmt.Sprintf("body must not contain more than %d rows", importMaxRows))
+			return
+		}
+
+		var rowErr rowError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &rowErr):
+			summary.Total++
+			report = append(report, importRow{Row: n, Status: "failed", Error: rowErr.Error()})
+			summary.Failed++
+			continue
+		case errors.As(err, &maxBytesError):
+			abort(http.StatusBadRequest, fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit))
+			return
+		case err != nil:
+			abort(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		summary.Total++
+
+		book.NormalizeISBN()
+
+		rv := validator.New()
+		if book.ValidateBook(rv); !rv.Valid() {
+			report = append(report, importRow{Row: n, Status: "failed", Errors: rv.Errors})
+			summary.Failed++
+			continue
+		}
+
+		report = append(report, importRow{Row: n})
+		batch = append(batch, book)
+		indexes = append(indexes, len(report)-1)
+
+		if len(batch) == importBatchSize {
+			if err = flush(); err != nil {
+				abortServerError(err)
+				return
+			}
+		}
+	}
+
+	if err = flush(); err != nil {
+		abortServerError(err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"dry_run": dryRun, "summary": summary, "rows": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
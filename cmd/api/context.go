@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/jsonlog"
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+)
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the id given to the request, or an empty string
+// for requests that did not pass through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// requestLogger returns a logger which tags every entry with the id of the
+// request.
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	if id := app.contextGetRequestID(r); id != "" {
+		return app.logger.With("request_id", id)
+	}
+	return app.logger
+}
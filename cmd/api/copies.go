@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func (app *application) listBookCopiesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.repos.BookRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	copies, err := app.repos.CopyRepo.GetAllForBook(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"counts": book.Copies, "copies": copies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBookCopyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Barcode   string `json:"barcode"`
+		Condition string `json:"condition"`
+		Location  string `json:"location"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bookCopy := &data.Copy{
+		BookID:    id,
+		Barcode:   strings.TrimSpace(input.Barcode),
+		Condition: input.Condition,
+		Location:  input.Location,
+	}
+
+	if bookCopy.Condition == "" {
+		bookCopy.Condition = "good"
+	}
+
+	v := validator.New()
+
+	if bookCopy.ValidateCopy(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.CopyRepo.Insert(bookCopy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/copies/%d", bookCopy.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"copy": bookCopy}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCopyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	bookCopy, err := app.repos.CopyRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": bookCopy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	bookCopy, err := app.repos.CopyRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Barcode   *string `json:"barcode"`
+		Condition *string `json:"condition"`
+		Location  *string `json:"location"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Barcode != nil {
+		bookCopy.Barcode = strings.TrimSpace(*input.Barcode)
+	}
+
+	if input.Condition != nil {
+		bookCopy.Condition = *input.Condition
+	}
+
+	if input.Location != nil {
+		bookCopy.Location = *input.Location
+	}
+
+	v := validator.New()
+
+	if bookCopy.ValidateCopy(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.CopyRepo.Update(bookCopy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": bookCopy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCopyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repos.CopyRepo.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrRecordInUse):
+			app.recordInUseResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "copy successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/jsonlog"
+	"crypto/subtle"
+	"net/http"
+)
+
+// debugRoutes serves the operational endpoints, behind basic authentication
+// when credentials are configured. They are exposed either on the metrics
+// listener or, when credentials are set, on the API port.
+func (app *application) debugRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/metrics", app.metrics.registry.Handler())
+	mux.HandleFunc("GET /debug/readyz", app.debugReadyzHandler)
+	mux.HandleFunc("GET /debug/log-level", app.showLogLevelHandler)
+	mux.HandleFunc("PUT /debug/log-level", app.updateLogLevelHandler)
+
+	username, password := app.config.metrics.username, app.config.metrics.password
+	if username == "" && password == "" {
+		return mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug", charset="UTF-8"`)
+			app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing debug credentials")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum log level of the running
+// process, for instance to turn on debug logging while chasing a problem.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"level": "must be one of debug, info, warn, error, fatal or off"})
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.requestLogger(r).PrintWarn("log level changed", map[string]any{
+		"from": previous.String(),
+		"to":   level.String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
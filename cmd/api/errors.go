@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
@@ -11,7 +12,7 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 }
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.requestLogger(r).PrintError(err, map[string]any{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -19,6 +20,9 @@ func (app *application) logError(r *http.Request, err error) {
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, msg any) {
 	env := envelope{"error": msg}
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
 
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -50,3 +54,77 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) recordInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record is still referenced by other resources and cannot be deleted"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) copyOnLoanResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this copy is already on loan"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) copyOnHoldResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this copy is set aside for another borrower's hold"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) holdInactiveResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this hold is no longer active"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) loanReturnedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this loan has already been returned"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) renewalLimitResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this loan has reached the maximum number of renewals"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) duplicateISBNResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a book with this ISBN already exists"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the Content-Type must be one of: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
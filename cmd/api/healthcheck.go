@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+// healthzHandler reports liveness: it only tells that the process is up and
+// serving, whatever the state of its dependencies.
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := envelope{
 		"status":      "available",
@@ -18,3 +22,75 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 }
+
+// readyzHandler reports whether the instance should receive traffic. It is
+// not ready while shutting down or when the database cannot be reached.
+// Probes are anonymous, so the reasons are only logged; the pool statistics
+// are served on /debug/readyz instead.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, false)
+}
+
+func (app *application) debugReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, true)
+}
+
+func (app *application) writeReadiness(w http.ResponseWriter, r *http.Request, detailed bool) {
+
+	checks := envelope{}
+	ready := true
+
+	if app.shuttingDown.Load() {
+		ready = false
+		checks["shutdown"] = "in progress"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	database := envelope{"status": "available"}
+
+	if detailed {
+		stats := app.db.Stats()
+		database["open_connections"] = stats.OpenConnections
+		database["in_use"] = stats.InUse
+		database["idle"] = stats.Idle
+		database["wait_count"] = stats.WaitCount
+		database["wait_duration"] = stats.WaitDuration.String()
+	}
+
+	if err := app.db.PingContext(ctx); err != nil {
+		ready = false
+		database["status"] = "unavailable"
+		app.logError(r, err)
+	} else if status, err := app.migrator.Status(ctx); err != nil {
+		ready = false
+		database["status"] = "unavailable"
+		app.logError(r, err)
+	} else {
+		database["schema_version"] = status.Version
+		database["schema_dirty"] = status.Dirty
+		database["schema_latest"] = status.Latest
+	}
+
+	checks["database"] = database
+
+	data := envelope{
+		"status":      "ready",
+		"environment": app.config.env,
+		"version":     version,
+		"checks":      checks,
+	}
+
+	code := http.StatusOK
+	if !ready {
+		data["status"] = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, code, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
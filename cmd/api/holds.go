@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readOwnHold loads the hold named by the id parameter, reporting holds of
+// other users as not found.
+func (app *application) readOwnHold(w http.ResponseWriter, r *http.Request) (*data.Hold, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	hold, err := app.repos.HoldRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return nil, false
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if hold.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return hold, true
+}
+
+func (app *application) listMyHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	holds, err := app.repos.HoldRepo.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBookHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	holds, err := app.repos.HoldRepo.GetQueue(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBookHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.repos.BookRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hold := &data.Hold{BookID: book.ID, UserID: app.contextGetUser(r).ID}
+
+	v := validator.New()
+
+	err = app.repos.HoldRepo.Insert(hold, app.config.holds.window)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNoCopies):
+			v.AddError("book", "has no copies that can be held")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateHold):
+			v.AddError("hold", "you already have a hold on this book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/holds/%d", hold.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold, ok := app.readOwnHold(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold, ok := app.readOwnHold(w, r)
+	if !ok {
+		return
+	}
+
+	if !hold.Active() {
+		app.holdInactiveResponse(w, r)
+		return
+	}
+
+	err := app.repos.HoldRepo.Cancel(hold, app.config.holds.window)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			app.editConflictResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sweepHolds periodically expires holds whose copy was not collected in
+// time and passes the copy on to the next hold in line. It returns once ctx
+// is cancelled.
+func (app *application) sweepHolds(ctx context.Context) {
+	ticker := time.NewTicker(app.config.holds.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := app.repos.HoldRepo.ExpireStale(app.config.holds.window)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				continue
+			}
+
+			if expired > 0 {
+				app.logger.PrintInfo("expired stale holds", map[string]any{
+					"count": expired,
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (app *application) checkoutLoanHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		CopyID  int64  `json:"copy_id"`
+		Barcode string `json:"barcode"`
+		UserID  int64  `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Barcode = strings.TrimSpace(input.Barcode)
+
+	v := validator.New()
+	v.Check(input.CopyID > 0 || input.Barcode != "", "copy_id", "either copy_id or barcode must be provided")
+	v.Check(input.CopyID == 0 || input.Barcode == "", "copy_id", "must not be provided together with barcode")
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Barcode != "" {
+		bookCopy, err := app.repos.CopyRepo.GetByBarcode(input.Barcode)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("barcode", "must reference an existing copy")
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		input.CopyID = bookCopy.ID
+	}
+
+	loan, err := app.repos.LoanRepo.Checkout(input.CopyID, input.UserID, time.Now().Add(app.config.loans.period), app.config.holds.window)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownCopy):
+			v.AddError("copy_id", "must reference an existing copy")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownUser):
+			v.AddError("user_id", "must reference an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyOnLoan):
+			app.copyOnLoanResponse(w, r)
+		case errors.Is(err, data.ErrCopyOnHold):
+			app.copyOnHoldResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/loans/%d", loan.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"loan": loan}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLoanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err := app.repos.LoanRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) renewLoanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err := app.repos.LoanRepo.Renew(id, app.config.loans.period, app.config.loans.maxRenewals)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLoanReturned):
+			app.loanReturnedResponse(w, r)
+		case errors.Is(err, data.ErrRenewalLimit):
+			app.renewalLimitResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) returnLoanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loan, err := app.repos.LoanRepo.Return(id, app.config.holds.window)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLoanReturned):
+			app.loanReturnedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOverdueLoansHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.SortBy = app.readString(qs, "sort", "due_at")
+	input.SortSafelist = []string{"due_at", "-due_at", "checked_out_at", "-checked_out_at"}
+
+	if input.ValidateFilters(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loans, metadata, err := app.repos.LoanRepo.GetOverdue(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "loans": loans}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
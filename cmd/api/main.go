@@ -3,36 +3,87 @@ package main
 import (
 	"bookworm.snnafi.dev/internal/data"
 	"bookworm.snnafi.dev/internal/jsonlog"
+	"bookworm.snnafi.dev/internal/mailer"
+	"bookworm.snnafi.dev/internal/migrate"
+	"bookworm.snnafi.dev/migrations"
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	_ "github.com/lib/pq"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port          int
+	env           string
+	shutdownDelay time.Duration
+	db            struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps          float64
+		burst        int
+		enabled      bool
+		suggestRPS   float64
+		suggestBurst int
+	}
+	permissions struct {
+		defaults []string
+	}
+	search struct {
+		fuzzyThreshold float64
+	}
+	loans struct {
+		period      time.Duration
+		maxRenewals int
+	}
+	holds struct {
+		window        time.Duration
+		sweepInterval time.Duration
+	}
+	log struct {
+		level       jsonlog.Level
+		format      jsonlog.Format
+		stackTraces bool
+		sample      int
+	}
+	metrics struct {
+		addr     string
+		username string
+		password string
+	}
+	migrate struct {
+		action string
+		check  bool
+	}
+	mailer struct {
+		backend string
+		dir     string
+		sender  string
 	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	repos  data.Repositories
+	config       config
+	logger       *jsonlog.Logger
+	db           *sql.DB
+	migrator     *migrate.Migrator
+	metrics      *appMetrics
+	repos        data.Repositories
+	mailer       mailer.Mailer
+	wg           sync.WaitGroup
+	shuttingDown atomic.Bool
 }
 
 func main() {
@@ -40,6 +91,7 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4001, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdownDelay, "shutdown-delay", 0, "How long to keep serving after readiness reports 503 on shutdown")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("BOOKWORM_DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -49,10 +101,63 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.suggestRPS, "limiter-suggest-rps", 10, "Rate limiter maximum requests per second for /v1/suggest")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst for /v1/suggest")
+
+	flag.Float64Var(&cfg.search.fuzzyThreshold, "search-fuzzy-threshold", 0.3, "Minimum trigram similarity for fuzzy name matches (0-1)")
+
+	flag.DurationVar(&cfg.loans.period, "loan-period", 14*24*time.Hour, "Loan period, also added by each renewal")
+	flag.IntVar(&cfg.loans.maxRenewals, "loan-max-renewals", 2, "Maximum number of renewals per loan")
+
+	flag.DurationVar(&cfg.holds.window, "hold-window", 72*time.Hour, "How long a returned copy is set aside for the next hold")
+	flag.DurationVar(&cfg.holds.sweepInterval, "hold-sweep-interval", time.Minute, "How often stale holds are expired and queues advanced")
+
+	cfg.log.level = jsonlog.LevelInfo
+	flag.Func("log-level", "Minimum log level (debug|info|warn|error|fatal|off) (default \"info\")", func(val string) error {
+		level, err := jsonlog.ParseLevel(val)
+		cfg.log.level = level
+		return err
+	})
+	flag.Func("log-format", "Log format (json|text) (default \"json\")", func(val string) error {
+		format, err := jsonlog.ParseFormat(val)
+		cfg.log.format = format
+		return err
+	})
+	flag.BoolVar(&cfg.log.stackTraces, "log-stack-traces", false, "Include stack traces in error log entries")
+	flag.IntVar(&cfg.log.sample, "log-sample", 0, "Maximum entries per second with the same message, 0 for no limit")
+
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Serve the /debug endpoints (metrics, log level) on a separate listener at this address, e.g. localhost:9090")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", "", "Basic auth username for the /debug endpoints; required to expose them on the API port")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", os.Getenv("BOOKWORM_METRICS_PASSWORD"), "Basic auth password for the /debug endpoints")
+
+	flag.StringVar(&cfg.migrate.action, "migrate", "", "Run database migrations and exit (up|down|status|to=N)")
+	flag.BoolVar(&cfg.migrate.check, "migrate-check", false, "Refuse to start if the database schema is behind the embedded migrations")
+
+	flag.StringVar(&cfg.mailer.backend, "mailer", "log", "Mailer backend (log|file)")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "tmp/mail", "Directory the file mailer writes messages to")
+	flag.StringVar(&cfg.mailer.sender, "mailer-sender", "Bookworm <no-reply@bookworm.snnafi.dev>", "Mailer sender")
+
+	cfg.permissions.defaults = []string{data.PermissionBooksRead}
+	flag.Func("default-permissions", "Comma separated permissions granted to new users (default \"books:read\")", func(val string) error {
+		cfg.permissions.defaults = strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == ' ' })
+		return nil
+	})
 
 	flag.Parse()
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logger := jsonlog.New(os.Stdout, cfg.log.level,
+		jsonlog.WithFormat(cfg.log.format),
+		jsonlog.WithStackTraces(cfg.log.stackTraces),
+		jsonlog.WithSampling(cfg.log.sample),
+	)
+
+	if cfg.search.fuzzyThreshold < 0 || cfg.search.fuzzyThreshold > 1 {
+		logger.PrintFatal(errors.New("-search-fuzzy-threshold must be between 0 and 1"), nil)
+	}
+
+	if cfg.holds.window <= 0 || cfg.holds.sweepInterval <= 0 {
+		logger.PrintFatal(errors.New("-hold-window and -hold-sweep-interval must be greater than zero"), nil)
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -63,10 +168,46 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.migrate.action != "" {
+		err = runMigrations(logger, migrator, cfg.migrate.action)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	if cfg.migrate.check {
+		err = checkSchema(migrator)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	repos := data.NewRepositories(db)
+
+	err = repos.BookTypeRepo.Load()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	m, err := newMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		repos:  data.NewRepositories(db),
+		config:   cfg,
+		logger:   logger,
+		db:       db,
+		migrator: migrator,
+		metrics:  newAppMetrics(db),
+		repos:    repos,
+		mailer:   m,
 	}
 
 	err = app.serve()
@@ -76,6 +217,17 @@ func main() {
 
 }
 
+func newMailer(cfg config) (mailer.Mailer, error) {
+	switch cfg.mailer.backend {
+	case "log":
+		return mailer.NewLogMailer(os.Stdout, cfg.mailer.sender), nil
+	case "file":
+		return mailer.NewFileMailer(cfg.mailer.dir, cfg.mailer.sender)
+	default:
+		return nil, fmt.Errorf("unknown mailer backend %q", cfg.mailer.backend)
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/metrics"
+	"database/sql"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type appMetrics struct {
+	registry         *metrics.Registry
+	requests         *metrics.CounterVec
+	requestsInFlight *metrics.GaugeVec
+	requestDuration  *metrics.HistogramVec
+	rateLimited      *metrics.CounterVec
+	panics           *metrics.CounterVec
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	reg := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:         reg,
+		requests:         reg.NewCounterVec("bookworm_http_requests_total", "Number of HTTP requests served.", "method", "route", "status"),
+		requestsInFlight: reg.NewGaugeVec("bookworm_http_requests_in_flight", "Number of HTTP requests currently being served."),
+		requestDuration:  reg.NewHistogramVec("bookworm_http_request_duration_seconds", "Time taken to serve HTTP requests.", metrics.DefaultBuckets, "method", "route", "status"),
+		rateLimited:      reg.NewCounterVec("bookworm_rate_limited_total", "Number of requests rejected by a rate limiter.", "limiter"),
+		panics:           reg.NewCounterVec("bookworm_panics_recovered_total", "Number of panics recovered.", "source"),
+	}
+
+	stat := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+
+	reg.NewGaugeFunc("bookworm_db_open_connections", "Number of established database connections, in use or idle.", stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	reg.NewGaugeFunc("bookworm_db_in_use_connections", "Number of database connections currently in use.", stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	reg.NewGaugeFunc("bookworm_db_idle_connections", "Number of idle database connections.", stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	reg.NewGaugeFunc("bookworm_db_max_open_connections", "Maximum number of open database connections.", stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	reg.NewCounterFunc("bookworm_db_wait_count_total", "Number of times a database connection had to be waited for.", stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	reg.NewCounterFunc("bookworm_db_wait_duration_seconds_total", "Total time spent waiting for a database connection.", stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	reg.NewCounterFunc("bookworm_db_max_idle_time_closed_total", "Number of connections closed because of the idle time limit.", stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+
+	reg.RegisterRuntime()
+
+	return m
+}
+
+// recordMetrics counts and times every request by method, route and status.
+// The route is resolved before the request is served so that requests
+// turned away early, by the rate limiter or authentication, are labelled too.
+func (app *application) recordMetrics(route func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.requestsInFlight.Inc()
+		defer app.metrics.requestsInFlight.Dec()
+
+		pattern := route(r)
+
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		method := metricsMethod(r.Method)
+		status := strconv.Itoa(rec.statusCode)
+
+		app.metrics.requests.Inc(method, pattern, status)
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), method, pattern, status)
+	})
+}
+
+// metricsMethod folds methods outside the standard set into "other", since
+// net/http accepts any token as a method and each label value is kept for
+// the life of the process.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// routePattern rebuilds the httprouter pattern of path by putting the
+// parameter names back in place of their values. Parameters are numeric
+// ids and never collide with a static segment in a request that routes
+// successfully.
+func routePattern(path string, params httprouter.Params) string {
+	segments := strings.Split(path, "/")
+
+	i := 0
+	for j, segment := range segments {
+		if i < len(params) && segment == params[i].Value {
+			segments[j] = ":" + params[i].Key
+			i++
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"golang.org/x/time/rate"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
 
 func (app *application) rateLimit(next http.Handler) http.Handler {
+	return app.rateLimitWith("global", app.config.limiter.rps, app.config.limiter.burst)(next)
+}
+
+// rateLimitWith returns a per client IP rate limiting middleware with its own
+// bucket for every client, independent of any other limiter. The name labels
+// its rejections in the metrics.
+func (app *application) rateLimitWith(name string, rps float64, burst int) func(http.Handler) http.Handler {
 
 	type client struct {
 		limiter  *rate.Limiter
@@ -35,32 +49,122 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		}
 	}()
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-			}
+			if app.config.limiter.enabled {
+				ip, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
 
-			app.logger.PrintInfo(ip, nil)
+				mu.Lock()
 
-			mu.Lock()
+				if _, found := clients[ip]; !found {
+					clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+				}
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-			}
+				clients[ip].lastSeen = time.Now()
+
+				if !clients[ip].limiter.Allow() {
+					mu.Unlock()
+					app.metrics.rateLimited.Inc(name)
+					app.rateLimitExceededResponse(w, r)
+					return
+				}
 
-			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestIDPattern bounds the ids accepted from clients, so that they are
+// safe to echo back and to log.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID tags the request with the client's X-Request-ID, or a fresh one
+// when it is missing or unusable, and echoes it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				app.serverErrorResponse(w, r, err)
 				return
 			}
+			id = hex.EncodeToString(b)
+		}
 
-			mu.Unlock()
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// responseRecorder captures the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if !rec.wroteHeader {
+		rec.statusCode = statusCode
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// the streaming export relies on for flushing.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequest writes an access log line for every request once it has been
+// served.
+func (app *application) logRequest(route func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
 		}
 
-		next.ServeHTTP(w, r)
+		// Every request gets an entry, so the access log is not sampled.
+		app.requestLogger(r).Unsampled().PrintInfo("request", map[string]any{
+			"request_method": r.Method,
+			"request_url":    r.URL.RequestURI(),
+			"route":          route(r),
+			"status":         rec.statusCode,
+			"bytes":          rec.bytes,
+			"duration":       time.Since(start).String(),
+			"remote_ip":      ip,
+		})
 	})
 }
 
@@ -69,6 +173,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 
 		defer func() {
 			if err := recover(); err != nil {
+				app.metrics.panics.Inc("http")
 				w.Header().Set("Connection", "close")
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
@@ -77,3 +182,95 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.repos.UserRepo.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.repos.PermissionRepo.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/jsonlog"
+	"bookworm.snnafi.dev/internal/migrate"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runMigrations carries out the -migrate action: up, down (one step), status
+// or to=N.
+func runMigrations(logger *jsonlog.Logger, m *migrate.Migrator, action string) error {
+	ctx := context.Background()
+
+	var applied []migrate.Migration
+	var err error
+	direction := "up"
+
+	switch {
+	case action == "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		pending := make([]string, 0, len(status.Pending))
+		for _, mig := range status.Pending {
+			pending = append(pending, fmt.Sprintf("%d_%s", mig.Version, mig.Name))
+		}
+
+		logger.PrintInfo("migration status", map[string]any{
+			"version": status.Version,
+			"dirty":   status.Dirty,
+			"latest":  status.Latest,
+			"pending": pending,
+		})
+		return nil
+	case action == "up":
+		applied, err = m.Up(ctx)
+	case action == "down":
+		direction = "down"
+		applied, err = m.Down(ctx)
+	case strings.HasPrefix(action, "to="):
+		version, convErr := strconv.ParseInt(strings.TrimPrefix(action, "to="), 10, 64)
+		if convErr != nil || version < 0 {
+			return fmt.Errorf("invalid -migrate target %q", action)
+		}
+
+		status, statusErr := m.Status(ctx)
+		if statusErr != nil {
+			return statusErr
+		}
+		if version < status.Version {
+			direction = "down"
+		}
+
+		applied, err = m.To(ctx, version)
+	default:
+		return fmt.Errorf("invalid -migrate action %q (must be up, down, status or to=N)", action)
+	}
+
+	for _, mig := range applied {
+		logger.PrintInfo("applied migration", map[string]any{
+			"version":   mig.Version,
+			"name":      mig.Name,
+			"direction": direction,
+		})
+	}
+
+	return err
+}
+
+// checkSchema refuses to serve against a database that is missing embedded
+// migrations, since the code would then run ahead of the schema.
+func checkSchema(m *migrate.Migrator) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.Behind() {
+		return fmt.Errorf("database schema is at version %d (dirty: %t) but the binary expects %d; run with -migrate=up",
+			status.Version, status.Dirty, status.Latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+func (app *application) listReadingProgressHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.ReadingStatuses...), "status", "must be one of want_to_read, reading, finished or abandoned")
+	}
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.SortBy = app.readString(qs, "sort", "-updated_at")
+	input.SortSafelist = []string{"updated_at", "-updated_at", "started_on", "-started_on", "finished_on", "-finished_on", "name", "-name"}
+
+	if input.ValidateFilters(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	progress, metadata, err := app.repos.ReadingProgressRepo.GetAllForUser(app.contextGetUser(r).ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "progress": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReadingProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	progress, err := app.repos.ReadingProgressRepo.Get(app.contextGetUser(r).ID, id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"progress": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putReadingProgressHandler records progress on a book, creating the entry
+// on first use. Fields left out keep their current value.
+func (app *application) putReadingProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	created := false
+
+	progress, err := app.repos.ReadingProgressRepo.Get(user.ID, id)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		book, err := app.repos.BookRepo.Get(id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.notFoundResponse(w, r)
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		progress = &data.ReadingProgress{UserID: user.ID, BookID: book.ID, Status: data.ReadingStatusWantToRead, Book: book}
+		created = true
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !created {
+		expected, err := app.readVersionHeader(ifMatch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if expected != progress.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		Status      *string `json:"status"`
+		CurrentPage *int    `json:"current_page"`
+		StartedOn   *string `json:"started_on"`
+		FinishedOn  *string `json:"finished_on"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.CurrentPage != nil {
+		progress.CurrentPage = *input.CurrentPage
+	}
+
+	if input.StartedOn != nil {
+		progress.StartedOn = *input.StartedOn
+	}
+
+	if input.FinishedOn != nil {
+		progress.FinishedOn = *input.FinishedOn
+	}
+
+	if input.Status != nil && *input.Status != progress.Status {
+		progress.ApplyStatus(*input.Status, time.Now())
+	}
+
+	v := validator.New()
+
+	if progress.ValidateReadingProgress(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if created {
+		err = app.repos.ReadingProgressRepo.Insert(progress)
+	} else {
+		err = app.repos.ReadingProgressRepo.Update(progress)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"progress": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReadingProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repos.ReadingProgressRepo.Delete(app.contextGetUser(r).ID, id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "reading progress successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReadingStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	year := app.readInt(qs, "year", time.Now().Year(), v)
+	v.Check(year >= 1900 && year <= 9999, "year", "must be between 1900 and 9999")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.repos.ReadingProgressRepo.Stats(app.contextGetUser(r).ID, year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
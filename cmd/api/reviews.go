@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// readBookReview loads the review named by the review_id parameter, which
+// must belong to the book named by the id parameter.
+func (app *application) readBookReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	reviewID, err := app.readNamedIDParam(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.repos.ReviewRepo.Get(reviewID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return nil, false
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if review.BookID != bookID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return review, true
+}
+
+func (app *application) listBookReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.SortBy = app.readString(qs, "sort", "-created_at")
+	input.SortSafelist = []string{"created_at", "-created_at", "rating", "-rating"}
+
+	if input.ValidateFilters(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	book, err := app.repos.BookRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, metadata, err := app.repos.ReviewRepo.GetAllForBook(book.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"average_rating": book.AverageRating,
+		"ratings_count":  book.RatingsCount,
+		"metadata":       metadata,
+		"reviews":        reviews,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBookReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review := &data.Review{
+		BookID:   id,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Rating:   input.Rating,
+		Text:     input.Text,
+	}
+
+	v := validator.New()
+
+	if review.ValidateReview(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ReviewRepo.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("review", "you have already reviewed this book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/books/%d/reviews/%d", review.BookID, review.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBookReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readBookReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateBookReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readBookReview(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		expected, err := app.readVersionHeader(ifMatch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if expected != review.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		Rating *int    `json:"rating"`
+		Text   *string `json:"text"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Text != nil {
+		review.Text = *input.Text
+	}
+
+	v := validator.New()
+
+	if review.ValidateReview(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ReviewRepo.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteBookReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readBookReview(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.repos.ReviewRepo.Delete(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownBook), errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bookworm.snnafi.dev/internal/data"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"strings"
 )
 
 func (app *application) routes() http.Handler {
@@ -12,12 +14,121 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthzHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/books", app.listBooksHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/books", app.createBookHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books", app.requirePermission(data.PermissionBooksWrite, app.createBookHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/books/:id", app.showBookHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.updateBookHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.deleteBookHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.requirePermission(data.PermissionBooksWrite, app.updateBookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.requirePermission(data.PermissionBooksWrite, app.deleteBookHandler))
 
-	return app.recoverPanic(app.rateLimit(router))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews", app.listBookReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/reviews", app.requireActivatedUser(app.createBookReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews/:review_id", app.showBookReviewHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id/reviews/:review_id", app.requireActivatedUser(app.updateBookReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id", app.requireActivatedUser(app.deleteBookReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/copies", app.listBookCopiesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/copies", app.requirePermission(data.PermissionBooksWrite, app.createBookCopyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/copies/:id", app.showCopyHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/copies/:id", app.requirePermission(data.PermissionBooksWrite, app.updateCopyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/copies/:id", app.requirePermission(data.PermissionBooksWrite, app.deleteCopyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/holds", app.requirePermission(data.PermissionLoansWrite, app.listBookHoldsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/holds", app.requireActivatedUser(app.createBookHoldHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/holds/:id", app.requireActivatedUser(app.showHoldHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/holds/:id", app.requireActivatedUser(app.cancelHoldHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/loans", app.requirePermission(data.PermissionLoansWrite, app.checkoutLoanHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/loans/:id", app.requirePermission(data.PermissionLoansWrite, app.showLoanHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/loans/:id/renew", app.requirePermission(data.PermissionLoansWrite, app.renewLoanHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/loans/:id/return", app.requirePermission(data.PermissionLoansWrite, app.returnLoanHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/types", app.listBookTypesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/types", app.requirePermission(data.PermissionBooksWrite, app.createBookTypeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/types/:id", app.showBookTypeHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/types/:id", app.requirePermission(data.PermissionBooksWrite, app.updateBookTypeHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/types/:id", app.requirePermission(data.PermissionBooksWrite, app.deleteBookTypeHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/authors", app.listAuthorsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/authors", app.requirePermission(data.PermissionBooksWrite, app.createAuthorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/authors/:id", app.showAuthorHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/authors/:id", app.requirePermission(data.PermissionBooksWrite, app.updateAuthorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/authors/:id", app.requirePermission(data.PermissionBooksWrite, app.deleteAuthorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/publishers", app.listPublishersHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/publishers", app.requirePermission(data.PermissionBooksWrite, app.createPublisherHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/publishers/:id", app.showPublisherHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/publishers/:id", app.requirePermission(data.PermissionBooksWrite, app.updatePublisherHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/publishers/:id", app.requirePermission(data.PermissionBooksWrite, app.deletePublisherHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/publishers/:id/books", app.listPublisherBooksHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/shelves", app.requireActivatedUser(app.listShelvesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/shelves", app.requireActivatedUser(app.createShelfHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shelves/:id", app.requireActivatedUser(app.showShelfHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/shelves/:id", app.requireActivatedUser(app.updateShelfHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shelves/:id", app.requireActivatedUser(app.deleteShelfHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/shelves/:id/books", app.requireActivatedUser(app.addShelfBookHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/shelves/:id/books", app.requireActivatedUser(app.reorderShelfBooksHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shelves/:id/books/:book_id", app.requireActivatedUser(app.removeShelfBookHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/me/progress", app.requireActivatedUser(app.listReadingProgressHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/progress/:id", app.requireActivatedUser(app.showReadingProgressHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/me/progress/:id", app.requireActivatedUser(app.putReadingProgressHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/progress/:id", app.requireActivatedUser(app.deleteReadingProgressHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/stats", app.requireActivatedUser(app.showReadingStatsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/holds", app.requireActivatedUser(app.listMyHoldsHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	// httprouter cannot register static segments next to the :id wildcard,
+	// so those routes are matched by the standard library mux first and
+	// everything else falls through to the router.
+	mux := http.NewServeMux()
+	mux.Handle("/", router)
+	mux.HandleFunc("GET /v1/books/isbn/{isbn}", app.showBookByISBNHandler)
+	mux.HandleFunc("GET /v1/books/export", app.exportBooksHandler)
+	mux.HandleFunc("POST /v1/books/import", app.requirePermission(data.PermissionBooksWrite, app.importBooksHandler))
+	mux.HandleFunc("GET /v1/shelves/shared/{slug}", app.showSharedShelfHandler)
+	mux.HandleFunc("GET /v1/loans/overdue", app.requirePermission(data.PermissionLoansWrite, app.listOverdueLoansHandler))
+
+	// Typeahead requests arrive on every keystroke, so they bypass the global
+	// limiter in favour of a more generous bucket of their own. They are
+	// anonymous and cacheable, hence no authentication either.
+	suggestLimit := app.rateLimitWith("suggest", app.config.limiter.suggestRPS, app.config.limiter.suggestBurst)
+
+	root := http.NewServeMux()
+	root.Handle("/", app.rateLimit(app.authenticate(mux)))
+	root.Handle("GET /v1/suggest", suggestLimit(http.HandlerFunc(app.suggestHandler)))
+
+	// Probes are polled by the orchestrator and must never be rate limited.
+	root.HandleFunc("GET /v1/healthz", app.healthzHandler)
+	root.HandleFunc("GET /v1/readyz", app.readyzHandler)
+
+	if app.config.metrics.addr == "" && app.config.metrics.username != "" {
+		root.Handle("/debug/", app.debugRoutes())
+	}
+
+	// Metrics are labelled by route pattern rather than by raw URL to keep
+	// the number of series bounded.
+	route := func(r *http.Request) string {
+		for _, m := range []*http.ServeMux{root, mux} {
+			if _, pattern := m.Handler(r); pattern != "" && pattern != "/" {
+				if _, path, found := strings.Cut(pattern, " "); found {
+					return path
+				}
+				return pattern
+			}
+		}
+
+		if handle, params, _ := router.Lookup(r.Method, r.URL.Path); handle != nil {
+			return routePattern(r.URL.Path, params)
+		}
+
+		return "unmatched"
+	}
+
+	return app.requestID(app.logRequest(route, app.recordMetrics(route, app.recoverPanic(root))))
 }
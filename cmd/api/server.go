@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,8 +23,50 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// The metrics and other debug endpoints get a listener of their own when
+	// configured, typically bound to a private interface.
+	var metricsSrv *http.Server
+	if app.config.metrics.addr != "" {
+		metricsSrv = &http.Server{
+			Addr:         app.config.metrics.addr,
+			Handler:      app.debugRoutes(),
+			ErrorLog:     log.New(app.logger, "", 0),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		ln, err := net.Listen("tcp", metricsSrv.Addr)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			err := metricsSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, nil)
+			}
+		}()
+
+		app.logger.PrintInfo("serving metrics", map[string]any{
+			"addr": metricsSrv.Addr,
+		})
+	}
+
 	shutdownError := make(chan error)
 
+	// Background jobs stop when jobs is cancelled during shutdown and are
+	// then drained along with the other background tasks.
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	app.background(func() {
+		app.refreshBookTypes(jobs)
+	})
+
+	app.background(func() {
+		app.sweepHolds(jobs)
+	})
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -32,17 +75,42 @@ func (app *application) serve() error {
 		// Read the signal from the quit channel. This code will block until a signal is // received.
 		s := <-quit
 
-		app.logger.PrintInfo("shutting down server", map[string]string{
+		app.logger.PrintInfo("shutting down server", map[string]any{
 			"signal": s.String()})
 
+		// Fail readiness first so that load balancers stop routing new
+		// requests here while in-flight ones are still being served.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdownDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		if metricsSrv != nil {
+			err = metricsSrv.Shutdown(ctx)
+			if err != nil {
+				shutdownError <- err
+				return
+			}
+		}
+
+		app.logger.PrintInfo("completing background tasks", map[string]any{
+			"addr": srv.Addr,
+		})
+
+		stopJobs()
+		app.wg.Wait()
+		shutdownError <- nil
 
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
+	app.logger.PrintInfo("starting server", map[string]any{
 		"addr": srv.Addr,
 		"env":  app.config.env,
 	})
@@ -57,7 +125,7 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.PrintInfo("stopped server", map[string]string{
+	app.logger.PrintInfo("stopped server", map[string]any{
 		"addr": srv.Addr,
 	})
 
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// readOwnShelf loads the shelf named by the id parameter. Shelves of other
+// users are reported as not found so that their existence is not revealed.
+func (app *application) readOwnShelf(w http.ResponseWriter, r *http.Request) (*data.Shelf, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	shelf, err := app.repos.ShelfRepo.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return nil, false
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if shelf.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return shelf, true
+}
+
+func (app *application) listShelvesHandler(w http.ResponseWriter, r *http.Request) {
+	shelves, err := app.repos.ShelfRepo.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shelves": shelves}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createShelfHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name   string `json:"name"`
+		Public bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	shelf := &data.Shelf{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+		Public: input.Public,
+	}
+
+	v := validator.New()
+
+	if shelf.ValidateShelf(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ShelfRepo.Insert(shelf)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateShelf) {
+			v.AddError("name", "you already have a shelf with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/shelves/%d", shelf.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"shelf": shelf}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeShelf(w, r, shelf)
+}
+
+func (app *application) showSharedShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, err := app.repos.ShelfRepo.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeShelf(w, r, shelf)
+}
+
+// writeShelf responds with a shelf and one page of its books, paginated
+// like the book list.
+func (app *application) writeShelf(w http.ResponseWriter, r *http.Request, shelf *data.Shelf) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.SortBy = app.readString(qs, "sort", "position")
+	input.SortSafelist = []string{"position", "-position", "added_at", "-added_at", "name", "-name", "author", "-author"}
+
+	if input.ValidateFilters(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, metadata, err := app.repos.ShelfRepo.GetBooks(shelf.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shelf": shelf, "metadata": metadata, "books": books}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		expected, err := app.readVersionHeader(ifMatch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if expected != shelf.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		Name   *string `json:"name"`
+		Public *bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		shelf.Name = *input.Name
+	}
+
+	if input.Public != nil {
+		shelf.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if shelf.ValidateShelf(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ShelfRepo.Update(shelf)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateShelf):
+			v.AddError("name", "you already have a shelf with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shelf": shelf}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.repos.ShelfRepo.Delete(shelf.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "shelf successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addShelfBookHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BookID   int64 `json:"book_id"`
+		Position int   `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.BookID > 0, "book_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ShelfRepo.AddBook(shelf.ID, input.BookID, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownBook):
+			v.AddError("book_id", "must reference an existing book")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateShelfBook):
+			v.AddError("book_id", "this book is already on the shelf")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "book successfully added to shelf"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeShelfBookHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	bookID, err := app.readNamedIDParam(r, "book_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.repos.ShelfRepo.RemoveBook(shelf.ID, bookID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully removed from shelf"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) reorderShelfBooksHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := app.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BookIDs []int64 `json:"book_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.BookIDs != nil, "book_ids", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.repos.ShelfRepo.ReorderBooks(shelf.ID, input.BookIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInvalidShelfOrder):
+			v.AddError("book_ids", "must list every book on the shelf exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeShelf(w, r, shelf)
+}
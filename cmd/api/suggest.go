@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bookworm.snnafi.dev/internal/data"
+	"bookworm.snnafi.dev/internal/validator"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+const suggestCacheMaxAge = 5 * time.Minute
+
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := app.readString(qs, "prefix", "")
+	v.Check(utf8.RuneCountInString(prefix) >= 2, "prefix", "must be at least 2 characters long")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+
+	field := app.readString(qs, "field", "name")
+	_, known := data.SuggestionFields[field]
+	v.Check(known, "field", "must be one of name, author or publisher")
+
+	limit := app.readInt(qs, "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.repos.SuggestionRepo.Get(field, prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(suggestCacheMaxAge.Seconds())))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
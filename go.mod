@@ -5,7 +5,6 @@ go 1.22.2
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
-	golang.org/x/text v0.16.0
+	golang.org/x/crypto v0.24.0
+	golang.org/x/time v0.5.0
 )
-
-require golang.org/x/time v0.5.0 // indirect
@@ -0,0 +1,391 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+var (
+	ErrDuplicateAuthor = errors.New("duplicate author")
+	ErrUnknownAuthor   = errors.New("unknown author")
+)
+
+type AuthorRole string
+
+const (
+	AuthorRoleAuthor     AuthorRole = "author"
+	AuthorRoleTranslator AuthorRole = "translator"
+	AuthorRoleEditor     AuthorRole = "editor"
+)
+
+var AuthorRoles = []AuthorRole{AuthorRoleAuthor, AuthorRoleTranslator, AuthorRoleEditor}
+
+type Author struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Bio       string    `json:"bio,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+// BookAuthor is an author as credited on a particular book. The same type is
+// used to read the author references a client sends in a book payload, in
+// which case only ID and Role are set.
+type BookAuthor struct {
+	Author
+	Role AuthorRole `json:"role"`
+}
+
+func (author *Author) ValidateAuthor(v *validator.Validator) {
+	v.Check(author.Name != "", "name", "must be provided")
+	v.Check(len(author.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(author.Bio) <= 10_000, "bio", "must not be more than 10000 bytes long")
+}
+
+func ValidateBookAuthors(v *validator.Validator, authors []BookAuthor) {
+	v.Check(len(authors) <= 20, "authors", "must not contain more than 20 authors")
+
+	keys := make([]string, 0, len(authors))
+	for _, author := range authors {
+		v.Check(author.ID > 0, "authors", "must only contain valid author ids")
+		v.Check(validator.PermittedValue(author.Role, AuthorRoles...), "authors", "role must be one of author, translator or editor")
+		keys = append(keys, fmt.Sprintf("%d:%s", author.ID, author.Role))
+	}
+
+	v.Check(validator.Unique(keys), "authors", "must not contain duplicate values")
+}
+
+type AuthorRepository struct {
+	DB *sql.DB
+}
+
+func (repo AuthorRepository) GetAll(name string, filters Filters) ([]*Author, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), id, created_at, name, bio, version
+    FROM authors
+    WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	authors := make([]*Author, 0)
+
+	for rows.Next() {
+		var author Author
+		err = rows.Scan(&totalRecords, &author.ID, &author.CreatedAt, &author.Name, &author.Bio, &author.Version)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		authors = append(authors, &author)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return authors, metadata, nil
+}
+
+func (repo AuthorRepository) Insert(author *Author) error {
+	query := `INSERT INTO authors (name, bio) VALUES ($1, $2) RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, author.Name, author.Bio).Scan(&author.ID, &author.CreatedAt, &author.Version)
+	if err != nil {
+		if isUniqueViolation(err, "authors_name_unique_idx") {
+			return ErrDuplicateAuthor
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo AuthorRepository) Get(id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, bio, version FROM authors WHERE id = $1`
+
+	var author Author
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
+		&author.ID,
+		&author.CreatedAt,
+		&author.Name,
+		&author.Bio,
+		&author.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &author, nil
+}
+
+// Update also rebuilds the author column of the books crediting the author,
+// so that their search picks up the new name.
+func (repo AuthorRepository) Update(author *Author) error {
+	query := `UPDATE authors SET name = $1, bio = $2, version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, author.Name, author.Bio, author.ID, author.Version).Scan(&author.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "authors_name_unique_idx"):
+			return ErrDuplicateAuthor
+		default:
+			return err
+		}
+	}
+
+	bookIDs, err := creditedBookIDs(ctx, tx, author.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = rebuildAuthorColumn(ctx, tx, bookIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete also rebuilds the author column of the books which credited the
+// author from the credits they have left.
+func (repo AuthorRepository) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The credits go with the author, so the books are found first.
+	bookIDs, err := creditedBookIDs(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM authors WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err = rebuildAuthorColumn(ctx, tx, bookIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func creditedBookIDs(ctx context.Context, tx *sql.Tx, authorID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT book_id FROM book_authors WHERE author_id = $1`, authorID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// rebuildAuthorColumn sets the author column of the given books to the names
+// they credit, bumping the version of those that change. A book left with no
+// credits keeps its author so that it still has one.
+func rebuildAuthorColumn(ctx context.Context, tx *sql.Tx, bookIDs []int64) error {
+	if len(bookIDs) == 0 {
+		return nil
+	}
+
+	query := `UPDATE books SET author = credits.names, version = books.version + 1
+    FROM (
+        SELECT ba.book_id, string_agg(a.name, ', ' ORDER BY ba.position) AS names
+        FROM book_authors ba INNER JOIN authors a ON a.id = ba.author_id
+        WHERE ba.book_id = ANY($1)
+        GROUP BY ba.book_id
+    ) credits
+    WHERE books.id = credits.book_id AND books.author <> credits.names`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(bookIDs))
+	return err
+}
+
+// loadBookAuthors resolves the credited authors of every book in a single
+// query and attaches them to the books in place.
+func loadBookAuthors(ctx context.Context, q queryer, books ...*Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(books))
+	byID := make(map[int64]*Book, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+		byID[book.ID] = book
+	}
+
+	query := `SELECT ba.book_id, a.id, a.name, a.version, ba.role
+    FROM book_authors ba
+    INNER JOIN authors a ON a.id = ba.author_id
+    WHERE ba.book_id = ANY($1)
+    ORDER BY ba.book_id, ba.position, a.id`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var author BookAuthor
+		err = rows.Scan(&bookID, &author.ID, &author.Name, &author.Version, &author.Role)
+		if err != nil {
+			return err
+		}
+
+		book := byID[bookID]
+		book.Authors = append(book.Authors, author)
+	}
+
+	return rows.Err()
+}
+
+// saveBookAuthors replaces the author credits of a book and reloads them so
+// that the names are filled in. When the book has no free-text author the
+// credited names are copied into it so that the legacy author column and its
+// search stay useful.
+func saveBookAuthors(ctx context.Context, tx *sql.Tx, book *Book) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM book_authors WHERE book_id = $1`, book.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(book.Authors) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(book.Authors))
+	roles := make([]string, 0, len(book.Authors))
+	for _, author := range book.Authors {
+		ids = append(ids, author.ID)
+		roles = append(roles, string(author.Role))
+	}
+
+	query := `INSERT INTO book_authors (book_id, author_id, role, position)
+    SELECT $1, a.author_id, a.role, a.position - 1
+    FROM unnest($2::bigint[], $3::text[]) WITH ORDINALITY AS a(author_id, role, position)`
+
+	_, err = tx.ExecContext(ctx, query, book.ID, pq.Array(ids), pq.Array(roles))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrUnknownAuthor
+		}
+		return err
+	}
+
+	book.Authors = nil
+	if err = loadBookAuthors(ctx, tx, book); err != nil {
+		return err
+	}
+
+	if book.Author != "" {
+		return nil
+	}
+
+	query = `UPDATE books SET author = coalesce((
+        SELECT string_agg(a.name, ', ' ORDER BY ba.position)
+        FROM book_authors ba INNER JOIN authors a ON a.id = ba.author_id
+        WHERE ba.book_id = $1), '')
+    WHERE id = $1
+    RETURNING author`
+
+	return tx.QueryRowContext(ctx, query, book.ID).Scan(&book.Author)
+}
+
+type MockAuthorRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockAuthorRepository) GetAll(name string, filters Filters) ([]*Author, MetaData, error) {
+	return nil, MetaData{}, nil
+}
+
+func (repo MockAuthorRepository) Insert(author *Author) error {
+	return nil
+}
+
+func (repo MockAuthorRepository) Get(id int64) (*Author, error) {
+	return nil, nil
+}
+
+func (repo MockAuthorRepository) Update(author *Author) error {
+	return nil
+}
+
+func (repo MockAuthorRepository) Delete(id int64) error {
+	return nil
+}
@@ -4,51 +4,51 @@ import (
 	"bookworm.snnafi.dev/internal/validator"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var ErrInvalidBookTypeFormat = errors.New("invalid book type format")
+var (
+	ErrInvalidBookTypeFormat = errors.New("invalid book type format")
+	ErrDuplicateISBN         = errors.New("duplicate isbn")
+)
 
 type BookType int32
 
 func (t BookType) MarshalJSON() ([]byte, error) {
-	switch t {
-	case 1:
-		return []byte(`"Islamic"`), nil
-	case 2:
-		return []byte(`"Comparative Religion"`), nil
+	def, ok := bookTypes.lookup(t)
+	if !ok {
+		return []byte(`""`), nil
 	}
-	return []byte(`""`), nil
+	return json.Marshal(def.Name)
 }
 
+// UnmarshalJSON accepts either the slug or the display name of a book type.
 func (t *BookType) UnmarshalJSON(b []byte) error {
-	switch string(b) {
-	case `"Islamic"`:
-		*t = 1
-	case `"Comparative Religion"`:
-		*t = 2
-	default:
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return ErrInvalidBookTypeFormat
+	}
+
+	found := bookTypes.find(s)
+	if found == 0 {
 		return ErrInvalidBookTypeFormat
 	}
+
+	*t = found
 	return nil
 }
 
+// NewBookTypeFromString resolves a slug or display name, returning 0 when the
+// book type is unknown.
 func NewBookTypeFromString(s string) BookType {
-	switch cases.Title(language.Und, cases.NoLower).String(s) {
-	case "Islamic":
-		return Islamic
-	case "Comparative Religion":
-		return ComparativeReligion
-	default:
-		return 0
-	}
+	return bookTypes.find(s)
 }
 
 func (t *BookType) Scan(value any) error {
@@ -71,92 +71,758 @@ func (t *BookType) Scan(value any) error {
 //	return int32(t), nil
 //}
 
-const (
-	Islamic BookType = iota + 1
-	ComparativeReligion
-)
-
 type Book struct {
-	ID         int64      `json:"id"`
-	Name       string     `json:"name"`
-	Author     string     `json:"author"`
-	Publisher  string     `json:"publisher"`
-	Image      string     `json:"image"`
-	CoverImage string     `json:"cover_image,omitempty"`
-	Type       []BookType `json:"type,omitempty"`
-	CreatedAt  time.Time  `json:"-"`
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Author      string       `json:"author"`
+	Authors     []BookAuthor `json:"authors,omitempty"`
+	Publisher   string       `json:"publisher"`
+	PublisherID int64        `json:"publisher_id,omitempty"`
+	ISBN10      string       `json:"isbn10,omitempty"`
+	ISBN13      string       `json:"isbn13,omitempty"`
+	Image       string       `json:"image"`
+	CoverImage  string       `json:"cover_image,omitempty"`
+	Type        []BookType   `json:"type,omitempty"`
+	PageCount   int          `json:"page_count,omitempty"`
+	CreatedAt   time.Time    `json:"-"`
+	Version     int32        `json:"version"`
+	// AverageRating and RatingsCount summarise the reviews of the book and
+	// are maintained by ReviewRepository.
+	AverageRating float64 `json:"average_rating"`
+	RatingsCount  int     `json:"ratings_count"`
+	// Copies is only set when a single book is read.
+	Copies *CopyCounts `json:"copies,omitempty"`
+	// Rank and Headline are only set when listing books with a full-text
+	// search query, Similarity only when matching the name fuzzily.
+	Rank       float32 `json:"rank,omitempty"`
+	Headline   string  `json:"headline,omitempty"`
+	Similarity float32 `json:"similarity,omitempty"`
 }
 
 func (book *Book) ValidateBook(v *validator.Validator) {
 	v.Check(book.Name != "", "name", "must be provided")
-	v.Check(book.Author != "", "author", "must be provided")
-	v.Check(book.Publisher != "", "publisher", "must be provided")
+	v.Check(book.Author != "" || len(book.Authors) > 0, "author", "must be provided")
+	v.Check(book.Publisher != "" || book.PublisherID > 0, "publisher", "must be provided")
+	v.Check(book.PublisherID >= 0, "publisher_id", "must be a positive integer")
 	v.Check(book.Image != "", "image", "must be provided")
 	v.Check(book.Type != nil, "type", "must be provided")
 	v.Check(len(book.Type) >= 1, "type", "must contain at least 1 type")
 	v.Check(len(book.Type) <= 3, "type", "must not contain more than 3 types")
 	v.Check(validator.Unique(book.Type), "type", "must not contain duplicate values")
+	v.Check(book.PageCount >= 0, "page_count", "must not be negative")
+	v.Check(book.PageCount <= 100_000, "page_count", "must not be more than 100000")
+
+	for _, t := range book.Type {
+		_, known := bookTypes.lookup(t)
+		v.Check(known, "type", "must only contain known book types")
+	}
+
+	ValidateBookAuthors(v, book.Authors)
+
+	if book.ISBN10 != "" {
+		v.Check(validator.IsISBN10(book.ISBN10), "isbn10", "must be a valid ISBN-10")
+	}
+
+	if book.ISBN13 != "" {
+		v.Check(validator.IsISBN13(book.ISBN13), "isbn13", "must be a valid ISBN-13")
+	}
+
+	if book.ISBN10 != "" && book.ISBN13 != "" {
+		v.Check(validator.ISBN10To13(book.ISBN10) == book.ISBN13, "isbn13", "must identify the same book as isbn10")
+	}
+}
+
+// NormalizeISBN strips separators from both ISBNs and derives whichever form
+// is missing from the other one.
+func (book *Book) NormalizeISBN() {
+	book.ISBN10 = validator.NormalizeISBN(book.ISBN10)
+	book.ISBN13 = validator.NormalizeISBN(book.ISBN13)
+
+	if book.ISBN13 == "" && book.ISBN10 != "" {
+		book.ISBN13 = validator.ISBN10To13(book.ISBN10)
+	}
+
+	if book.ISBN10 == "" && book.ISBN13 != "" {
+		book.ISBN10 = validator.ISBN13To10(book.ISBN13)
+	}
+}
+
+// bookColumns lists the columns read into a Book by scanTargets, in order.
+const bookColumns = `books.id, books.created_at, books.name, books.author, books.publisher, coalesce(books.publisher_id, 0),
+    coalesce(books.isbn10, ''), coalesce(books.isbn13, ''), books.image, books.cover_image, books.types, books.page_count, books.version,
+    books.average_rating, books.ratings_count`
+
+// sortValue returns the value of a column in SortSafelist as it is stored in
+// a Cursor.
+func (book *Book) sortValue(column string) string {
+	switch column {
+	case "name":
+		return book.Name
+	case "author":
+		return book.Author
+	case "publisher":
+		return book.Publisher
+	case "relevance":
+		return strconv.FormatFloat(float64(-book.Rank), 'g', -1, 32)
+	case "similarity":
+		return strconv.FormatFloat(float64(-book.Similarity), 'g', -1, 32)
+	case "rating":
+		return strconv.FormatFloat(book.AverageRating, 'f', 2, 64)
+	default:
+		return strconv.FormatInt(book.ID, 10)
+	}
+}
+
+func (book *Book) scanTargets() []any {
+	return []any{
+		&book.ID,
+		&book.CreatedAt,
+		&book.Name,
+		&book.Author,
+		&book.Publisher,
+		&book.PublisherID,
+		&book.ISBN10,
+		&book.ISBN13,
+		&book.Image,
+		&book.CoverImage,
+		pq.Array(&book.Type),
+		&book.PageCount,
+		&book.Version,
+		&book.AverageRating,
+		&book.RatingsCount,
+	}
 }
 
 type BookRepository struct {
 	DB *sql.DB
 }
 
-func (repo BookRepository) GetAll(name string, types []BookType, filters Filters) ([]*Book, MetaData, error) {
+// BookFilter narrows down the books returned by GetAll and Export. The zero
+// value of every field disables the corresponding condition.
+type BookFilter struct {
+	Name        string
+	Types       []BookType
+	AuthorID    int64
+	PublisherID int64
+	// Query is a web search style full-text query matched against the name,
+	// author and publisher, in that order of weight.
+	Query string
+	// Fuzzy matches Name by trigram similarity instead of by full-text
+	// search, accepting names at least SimilarityThreshold similar.
+	Fuzzy               bool
+	SimilarityThreshold float64
+	// MinRating excludes books whose average rating is lower.
+	MinRating float64
+}
+
+// bookFilterConditions is the WHERE clause matching BookFilter.args, which
+// always occupy placeholders $1 to $7.
+const bookFilterConditions = bookNameCondition + `
+    AND ` + bookTypesCondition + `
+    AND ` + bookAuthorCondition + `
+    AND ` + bookPublisherCondition + `
+    AND ` + bookQueryCondition + `
+    AND ` + bookRatingCondition
+
+// The single conditions making up bookFilterConditions, kept apart so that
+// facet counts can leave out the dimension they are counting.
+const (
+	bookNameCondition      = `((NOT $6 AND to_tsvector('simple', books.name) @@ plainto_tsquery('simple', $1)) OR ($6 AND books.name % $1) OR $1 = '')`
+	bookTypesCondition     = `NOT EXISTS (SELECT 1 FROM unnest($2::text[]) AS wanted(ids) WHERE NOT books.types && string_to_array(wanted.ids, ','))`
+	bookAuthorCondition    = `(books.id IN (SELECT book_id FROM book_authors WHERE author_id = $3) OR $3 = 0)`
+	bookPublisherCondition = `(books.publisher_id = $4 OR $4 = 0)`
+	bookQueryCondition     = `(books.search @@ websearch_to_tsquery('simple', $5) OR $5 = '')`
+	bookRatingCondition    = `books.average_rating >= $7`
+)
+
+// bookSearchColumns are selected after bookColumns when listing books and
+// are read by searchScanTargets.
+const bookSearchColumns = `ts_rank(books.search, websearch_to_tsquery('simple', $5)),
+    CASE WHEN $5 = '' THEN '' ELSE ts_headline('simple', books.name || ' / ' || books.author || ' / ' || books.publisher,
+        websearch_to_tsquery('simple', $5), 'MaxFragments=2, MaxWords=20, MinWords=5') END,
+    CASE WHEN $6 THEN similarity(books.name, $1) ELSE 0 END`
+
+func (f BookFilter) args() []any {
+	return []any{f.Name, pq.Array(expandBookTypes(f.Types)), f.AuthorID, f.PublisherID, f.Query, f.Fuzzy, f.MinRating}
+}
+
+func (book *Book) searchScanTargets() []any {
+	return []any{&book.Rank, &book.Headline, &book.Similarity}
+}
+
+// withSearchSettings calls fn with a queryer on which the similarity
+// threshold of a fuzzy filter is in effect for the pg_trgm % operator.
+func (repo BookRepository) withSearchSettings(ctx context.Context, filter BookFilter, fn func(q queryer) error) error {
+	if !filter.Fuzzy {
+		return fn(repo.DB)
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = applySearchSettings(ctx, tx, filter); err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func applySearchSettings(ctx context.Context, tx *sql.Tx, filter BookFilter) error {
+	if !filter.Fuzzy {
+		return nil
+	}
+
+	threshold := strconv.FormatFloat(filter.SimilarityThreshold, 'f', -1, 64)
+	_, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`, threshold)
+	return err
+}
+
+// bookSortExpression maps a sort column to the SQL it orders by. relevance
+// is negated so that the best matches come first in ascending order.
+func bookSortExpression(column string) string {
+	switch column {
+	case "relevance":
+		return "-ts_rank(books.search, websearch_to_tsquery('simple', $5))"
+	case "similarity":
+		return "-similarity(books.name, $1)"
+	case "rating":
+		return "books.average_rating"
+	default:
+		return "books." + column
+	}
+}
+
+func (repo BookRepository) GetAll(filter BookFilter, filters Filters) ([]*Book, MetaData, error) {
+	if filters.Cursor != nil {
+		return repo.getAllByKeyset(filter, filters)
+	}
+
+	args := filter.args()
+
 	query := fmt.Sprintf(`SELECT
-    count(*) OVER(), id, created_at, name, author, publisher, image, cover_image, types
+    count(*) OVER(), %s, %s
     FROM books
-    WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-    AND (types @> $2 OR $2 = '{}')
+    WHERE %s
     ORDER BY %s %s, id ASC
-    LIMIT $3 OFFSET $4`,
-		filters.sortColumn(), filters.sortDirection())
+    LIMIT $%d OFFSET $%d`,
+		bookColumns, bookSearchColumns, bookFilterConditions, bookSortExpression(filters.sortColumn()), filters.sortDirection(),
+		len(args)+1, len(args)+2)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{name, pq.Array(types), filters.limit(), filters.offset()}
+	args = append(args, filters.limit(), filters.offset())
 
-	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	totalRecords := 0
+	books := make([]*Book, 0)
+
+	err := repo.withSearchSettings(ctx, filter, func(q queryer) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var book Book
+			err = rows.Scan(append(append([]any{&totalRecords}, book.scanTargets()...), book.searchScanTargets()...)...)
+			if err != nil {
+				return err
+			}
+
+			books = append(books, &book)
+		}
+
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, MetaData{}, err
 	}
 
-	defer rows.Close()
+	if err = loadBookAuthors(ctx, repo.DB, books...); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return books, metadata, nil
+
+}
+
+// getAllByKeyset pages through the books with a keyset condition on the sort
+// column and id instead of an OFFSET, and skips the total count.
+func (repo BookRepository) getAllByKeyset(filter BookFilter, filters Filters) ([]*Book, MetaData, error) {
+	args := filter.args()
+
+	column := bookSortExpression(filters.sortColumn())
+	condition, keysetArgs := filters.keysetCondition(column, "books.id", len(args)+1)
+	args = append(args, keysetArgs...)
+
+	query := fmt.Sprintf(`SELECT %s, %s
+    FROM books
+    WHERE %s
+    AND %s
+    ORDER BY %s
+    LIMIT $%d`,
+		bookColumns, bookSearchColumns, bookFilterConditions, condition, filters.keysetOrder(column, "books.id"), len(args)+1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args = append(args, filters.limit()+1)
 
-	totalRecords := 0
 	books := make([]*Book, 0)
 
-	for rows.Next() {
-		var book Book
-		err = rows.Scan(&totalRecords, &book.ID, &book.CreatedAt, &book.Name, &book.Author, &book.Publisher, &book.Image, &book.CoverImage, pq.Array(&book.Type))
+	err := repo.withSearchSettings(ctx, filter, func(q queryer) error {
+		rows, err := q.QueryContext(ctx, query, args...)
 		if err != nil {
-			return nil, MetaData{}, err
+			return err
 		}
 
-		books = append(books, &book)
+		defer rows.Close()
+
+		for rows.Next() {
+			var book Book
+			err = rows.Scan(append(book.scanTargets(), book.searchScanTargets()...)...)
+			if err != nil {
+				return err
+			}
+
+			books = append(books, &book)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, MetaData{}, err
 	}
 
-	if err = rows.Err(); err != nil {
+	hasMore := len(books) > filters.limit()
+	if hasMore {
+		books = books[:filters.limit()]
+	}
+
+	backward := filters.Cursor.Backward
+	if backward {
+		slices.Reverse(books)
+	}
+
+	if err = loadBookAuthors(ctx, repo.DB, books...); err != nil {
 		return nil, MetaData{}, err
 	}
 
-	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+	metadata := MetaData{PageSize: filters.PageSize}
+
+	if len(books) == 0 {
+		return books, metadata, nil
+	}
+
+	cursorAt := func(book *Book, backward bool) string {
+		return Cursor{SortBy: filters.SortBy, Value: book.sortValue(filters.sortColumn()), ID: book.ID, Backward: backward}.Encode()
+	}
+
+	started := filters.Cursor.ID != 0
+
+	if (!backward && hasMore) || (backward && started) {
+		metadata.NextCursor = cursorAt(books[len(books)-1], false)
+	}
+
+	if (backward && hasMore) || (!backward && started) {
+		metadata.PrevCursor = cursorAt(books[0], true)
+	}
 
 	return books, metadata, nil
+}
+
+// Export calls fn for every book matching filter, ordered by id. Rows are
+// read in batches from a server-side cursor so that the result set is never
+// held in memory; ctx bounds the whole export.
+func (repo BookRepository) Export(ctx context.Context, filter BookFilter, fn func(books []*Book) error) error {
+	tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DECLARE books_export NO SCROLL CURSOR FOR
+    SELECT ` + bookColumns + `
+    FROM books
+    WHERE ` + bookFilterConditions + `
+    ORDER BY books.id ASC`
 
+	if err = applySearchSettings(ctx, tx, filter); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, filter.args()...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, `FETCH FORWARD 500 FROM books_export`)
+		if err != nil {
+			return err
+		}
+
+		books := make([]*Book, 0, 500)
+
+		for rows.Next() {
+			var book Book
+			if err = rows.Scan(book.scanTargets()...); err != nil {
+				rows.Close()
+				return err
+			}
+			books = append(books, &book)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if len(books) == 0 {
+			return tx.Commit()
+		}
+
+		if err = loadBookAuthors(ctx, tx, books...); err != nil {
+			return err
+		}
+
+		if err = fn(books); err != nil {
+			return err
+		}
+	}
+}
+
+// SuggestName returns the existing book name most similar to the name of
+// filter with at least its trigram similarity threshold, for a "did you
+// mean" hint when a search finds nothing. Only the name is considered, and
+// there is no suggestion when some book matches it, since the search then
+// came up empty because of the other filters. An empty string means no
+// candidate.
+func (repo BookRepository) SuggestName(filter BookFilter) (string, error) {
+	if strings.TrimSpace(filter.Name) == "" {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nameOnly := BookFilter{Name: filter.Name, Fuzzy: filter.Fuzzy, SimilarityThreshold: filter.SimilarityThreshold}
+
+	query := `SELECT name FROM books WHERE name % $1 AND lower(name) <> lower($1)
+        AND NOT EXISTS (SELECT 1 FROM books WHERE ` + bookFilterConditions + `)
+        ORDER BY similarity(name, $1) DESC, id ASC LIMIT 1`
+
+	var suggestion string
+
+	// The % operator is used whatever the match mode, so the threshold is
+	// always applied.
+	err := repo.withSearchSettings(ctx, BookFilter{Fuzzy: true, SimilarityThreshold: filter.SimilarityThreshold}, func(q queryer) error {
+		rows, err := q.QueryContext(ctx, query, nameOnly.args()...)
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		if rows.Next() {
+			if err = rows.Scan(&suggestion); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	})
+
+	return suggestion, err
 }
 
+// publisherByNameQuery links a book given only a free-text publisher ($3) to
+// the publisher of that name, matched the same way as the migration which
+// introduced publishers.
+const publisherByNameQuery = `(SELECT id FROM publishers WHERE lower(name) = lower(trim($3)))`
+
 func (repo BookRepository) Insert(book *Book) error {
 
-	query := `INSERT INTO books (name, author, publisher, image, cover_image, types) VALUES
-              ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
-	args := []any{book.Name, book.Author, book.Publisher, book.Image, book.CoverImage, pq.Array(book.Type)}
+	query := `INSERT INTO books (name, author, publisher, publisher_id, image, cover_image, types, isbn10, isbn13, page_count) VALUES
+              ($1, $2, coalesce(nullif($3, ''), (SELECT name FROM publishers WHERE id = $4), ''), coalesce($4, ` + publisherByNameQuery + `), $5, $6, $7, nullif($8, ''), nullif($9, ''), $10)
+              RETURNING id, created_at, version, publisher, coalesce(publisher_id, 0)`
+	args := []any{book.Name, book.Author, book.Publisher, nullableID(book.PublisherID), book.Image, book.CoverImage, pq.Array(book.Type), book.ISBN10, book.ISBN13, book.PageCount}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return repo.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt)
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version, &book.Publisher, &book.PublisherID)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrUnknownPublisher
+		case isUniqueViolation(err, "books_isbn10_key"), isUniqueViolation(err, "books_isbn13_key"):
+			return ErrDuplicateISBN
+		default:
+			return err
+		}
+	}
+
+	if err = saveBookAuthors(ctx, tx, book); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertBatch inserts books with a single multi-row statement inside one
+// transaction. The returned slice holds one entry per book: nil when it was
+// created, ErrDuplicateISBN when it was skipped because its ISBN already
+// exists, or ErrUnknownPublisher/ErrUnknownAuthor when it references a
+// record that does not exist.
+func (repo BookRepository) InsertBatch(books []*Book) ([]error, error) {
+	results := make([]error, len(books))
+	if len(books) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = checkBatchReferences(ctx, tx, books, results); err != nil {
+		return nil, err
+	}
+
+	// Ids are allocated up front so that the rows returned by the insert
+	// can be matched back to the books they came from.
+	rows, err := tx.QueryContext(ctx, `SELECT nextval(pg_get_serial_sequence('books', 'id')) FROM generate_series(1, $1)`, len(books))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(books))
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		columns = make([][]any, 11)
+		pending = make(map[int64]int, len(books))
+	)
+
+	for i, book := range books {
+		if results[i] != nil {
+			continue
+		}
+
+		types := make([]string, 0, len(book.Type))
+		for _, t := range book.Type {
+			types = append(types, strconv.Itoa(int(t)))
+		}
+
+		book.ID = ids[i]
+		pending[book.ID] = i
+
+		for c, value := range []any{book.ID, book.Name, book.Author, book.Publisher, book.PublisherID, book.Image, book.CoverImage, strings.Join(types, ","), book.ISBN10, book.ISBN13, book.PageCount} {
+			columns[c] = append(columns[c], value)
+		}
+	}
+
+	query := `INSERT INTO books (id, name, author, publisher, publisher_id, image, cover_image, types, isbn10, isbn13, page_count)
+    SELECT i.id, i.name, i.author,
+        coalesce(nullif(i.publisher, ''), (SELECT name FROM publishers WHERE id = i.publisher_id), ''),
+        coalesce(nullif(i.publisher_id, 0), (SELECT id FROM publishers WHERE lower(name) = lower(trim(i.publisher)))), i.image, i.cover_image, string_to_array(i.types, ','), nullif(i.isbn10, ''), nullif(i.isbn13, ''), i.page_count
+    FROM unnest($1::bigint[], $2::text[], $3::text[], $4::text[], $5::bigint[], $6::text[], $7::text[], $8::text[], $9::text[], $10::text[], $11::integer[])
+        AS i(id, name, author, publisher, publisher_id, image, cover_image, types, isbn10, isbn13, page_count)
+    ON CONFLICT DO NOTHING
+    RETURNING id, created_at, version, publisher, coalesce(publisher_id, 0)`
+
+	args := make([]any, len(columns))
+	for c := range columns {
+		args[c] = pq.GenericArray{A: columns[c]}
+	}
+
+	rows, err = tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make(map[int64]bool, len(pending))
+	for rows.Next() {
+		var id, version, publisherID int64
+		var createdAt time.Time
+		var publisher string
+
+		if err = rows.Scan(&id, &createdAt, &version, &publisher, &publisherID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		book := books[pending[id]]
+		book.CreatedAt, book.Version, book.Publisher, book.PublisherID = createdAt, int32(version), publisher, publisherID
+		inserted[id] = true
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for id, i := range pending {
+		if !inserted[id] {
+			books[i].ID = 0
+			results[i] = ErrDuplicateISBN
+			continue
+		}
+
+		if err = saveBookAuthors(ctx, tx, books[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CheckBatch reports what InsertBatch would do with books without writing
+// anything: ErrDuplicateISBN is returned for a book whose ISBN is already
+// stored or used by an earlier book in the batch.
+func (repo BookRepository) CheckBatch(books []*Book) ([]error, error) {
+	results := make([]error, len(books))
+	if len(books) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := checkBatchReferences(ctx, repo.DB, books, results); err != nil {
+		return nil, err
+	}
+
+	var isbns []string
+	for _, book := range books {
+		if book.ISBN10 != "" {
+			isbns = append(isbns, book.ISBN10)
+		}
+		if book.ISBN13 != "" {
+			isbns = append(isbns, book.ISBN13)
+		}
+	}
+
+	taken := make(map[string]bool, len(isbns))
+
+	if len(isbns) > 0 {
+		rows, err := repo.DB.QueryContext(ctx, `SELECT coalesce(isbn10, ''), coalesce(isbn13, '')
+        FROM books WHERE isbn10 = ANY($1) OR isbn13 = ANY($1)`, pq.Array(isbns))
+		if err != nil {
+			return nil, err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var isbn10, isbn13 string
+			if err = rows.Scan(&isbn10, &isbn13); err != nil {
+				return nil, err
+			}
+			taken[isbn10], taken[isbn13] = true, true
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, book := range books {
+		if results[i] != nil {
+			continue
+		}
+
+		if (book.ISBN10 != "" && taken[book.ISBN10]) || (book.ISBN13 != "" && taken[book.ISBN13]) {
+			results[i] = ErrDuplicateISBN
+			continue
+		}
+
+		if book.ISBN10 != "" {
+			taken[book.ISBN10] = true
+		}
+		if book.ISBN13 != "" {
+			taken[book.ISBN13] = true
+		}
+	}
+
+	return results, nil
+}
+
+// checkBatchReferences sets ErrUnknownPublisher or ErrUnknownAuthor in
+// results for every book which references a record that does not exist.
+func checkBatchReferences(ctx context.Context, q queryer, books []*Book, results []error) error {
+	var publisherIDs, authorIDs []int64
+	for _, book := range books {
+		if book.PublisherID > 0 {
+			publisherIDs = append(publisherIDs, book.PublisherID)
+		}
+		for _, author := range book.Authors {
+			authorIDs = append(authorIDs, author.ID)
+		}
+	}
+
+	publishers, err := existingIDs(ctx, q, "publishers", publisherIDs)
+	if err != nil {
+		return err
+	}
+
+	authors, err := existingIDs(ctx, q, "authors", authorIDs)
+	if err != nil {
+		return err
+	}
+
+	for i, book := range books {
+		if book.PublisherID > 0 && !publishers[book.PublisherID] {
+			results[i] = ErrUnknownPublisher
+			continue
+		}
+
+		for _, author := range book.Authors {
+			if !authors[author.ID] {
+				results[i] = ErrUnknownAuthor
+			}
+		}
+	}
+
+	return nil
 }
 
 func (repo BookRepository) Get(id int64) (*Book, error) {
@@ -164,24 +830,15 @@ func (repo BookRepository) Get(id int64) (*Book, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, name, author, publisher, image, cover_image, types
+	query := `SELECT ` + bookColumns + `, ` + bookCopyColumns + `
     FROM books WHERE id = $1`
 
-	var book Book
+	book := Book{Copies: &CopyCounts{}}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
-		&book.ID,
-		&book.CreatedAt,
-		&book.Name,
-		&book.Author,
-		&book.Publisher,
-		&book.Image,
-		&book.CoverImage,
-		pq.Array(&book.Type),
-	)
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(append(book.scanTargets(), book.Copies.scanTargets()...)...)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -190,19 +847,88 @@ func (repo BookRepository) Get(id int64) (*Book, error) {
 		return nil, err
 	}
 
+	if err = loadBookAuthors(ctx, repo.DB, &book); err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// GetByISBN finds a book by either its ISBN-10 or ISBN-13.
+func (repo BookRepository) GetByISBN(isbn string) (*Book, error) {
+	isbn = validator.NormalizeISBN(isbn)
+
+	isbn13 := isbn
+	if validator.IsISBN10(isbn) {
+		isbn13 = validator.ISBN10To13(isbn)
+	}
+
+	if !validator.IsISBN13(isbn13) {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + bookColumns + `, ` + bookCopyColumns + `
+    FROM books WHERE isbn13 = $1 OR isbn10 = $2`
+
+	book := Book{Copies: &CopyCounts{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, isbn13, isbn).Scan(append(book.scanTargets(), book.Copies.scanTargets()...)...)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err = loadBookAuthors(ctx, repo.DB, &book); err != nil {
+		return nil, err
+	}
+
 	return &book, nil
 }
 
 func (repo BookRepository) Update(book *Book) error {
 
-	query := `UPDATE books SET name = $1, author = $2, publisher = $3, image = $4, cover_image = $5, types = $6 WHERE id = $7`
-	args := []any{book.Name, book.Author, book.Publisher, book.Image, book.CoverImage, pq.Array(book.Type), book.ID}
+	query := `UPDATE books SET name = $1, author = $2,
+    publisher = coalesce(nullif($3, ''), (SELECT name FROM publishers WHERE id = $4), ''), publisher_id = coalesce($4, ` + publisherByNameQuery + `),
+    image = $5, cover_image = $6, types = $7, isbn10 = nullif($8, ''), isbn13 = nullif($9, ''), page_count = $10, version = version + 1
+    WHERE id = $11 AND version = $12
+    RETURNING version, publisher, coalesce(publisher_id, 0)`
+	args := []any{book.Name, book.Author, book.Publisher, nullableID(book.PublisherID), book.Image, book.CoverImage, pq.Array(book.Type),
+		book.ISBN10, book.ISBN13, book.PageCount, book.ID, book.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := repo.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&book.Version, &book.Publisher, &book.PublisherID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isForeignKeyViolation(err):
+			return ErrUnknownPublisher
+		case isUniqueViolation(err, "books_isbn10_key"), isUniqueViolation(err, "books_isbn13_key"):
+			return ErrDuplicateISBN
+		default:
+			return err
+		}
+	}
+
+	if err = saveBookAuthors(ctx, tx, book); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (repo BookRepository) Delete(id int64) error {
@@ -214,6 +940,9 @@ func (repo BookRepository) Delete(id int64) error {
 
 	result, err := repo.DB.ExecContext(ctx, query, id)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
 		return err
 	}
 
@@ -233,18 +962,38 @@ type MockBookRepository struct {
 	DB *sql.DB
 }
 
-func (repo MockBookRepository) GetAll(name string, types []BookType, filters Filters) ([]*Book, MetaData, error) {
+func (repo MockBookRepository) GetAll(filter BookFilter, filters Filters) ([]*Book, MetaData, error) {
 	return nil, MetaData{}, nil
 }
 
+func (repo MockBookRepository) Export(ctx context.Context, filter BookFilter, fn func(books []*Book) error) error {
+	return nil
+}
+
+func (repo MockBookRepository) SuggestName(filter BookFilter) (string, error) {
+	return "", nil
+}
+
 func (repo MockBookRepository) Insert(book *Book) error {
 	return nil
 }
 
+func (repo MockBookRepository) InsertBatch(books []*Book) ([]error, error) {
+	return make([]error, len(books)), nil
+}
+
 func (repo MockBookRepository) Get(id int64) (*Book, error) {
 	return nil, nil
 }
 
+func (repo MockBookRepository) CheckBatch(books []*Book) ([]error, error) {
+	return make([]error, len(books)), nil
+}
+
+func (repo MockBookRepository) GetByISBN(isbn string) (*Book, error) {
+	return nil, nil
+}
+
 func (repo MockBookRepository) Update(book *Book) error {
 	return nil
 }
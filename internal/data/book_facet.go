@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BookFacetFields are the dimensions BookRepository.Facets can count.
+var BookFacetFields = []string{"type", "author", "publisher"}
+
+type FacetValue struct {
+	ID    int64  `json:"id"`
+	Slug  string `json:"slug,omitempty"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the requested facet counts keyed by field.
+type Facets map[string][]FacetValue
+
+// bookFacetQueries count the books matching every filter except the one on
+// the facet's own dimension, so that the counts show what selecting another
+// value would return. %d is the number of values to return.
+var bookFacetQueries = map[string]string{
+	"type": `(SELECT 'type', type_id::bigint, '', count(*)
+        FROM matched, unnest(matched.types) AS type_id
+        WHERE author_ok AND publisher_ok
+        GROUP BY type_id)`,
+	"author": `(SELECT 'author', authors.id, authors.name, count(DISTINCT matched.id)
+        FROM matched
+        INNER JOIN book_authors ON book_authors.book_id = matched.id
+        INNER JOIN authors ON authors.id = book_authors.author_id
+        WHERE type_ok AND publisher_ok
+        GROUP BY authors.id, authors.name
+        ORDER BY count(DISTINCT matched.id) DESC, authors.name ASC
+        LIMIT %d)`,
+	"publisher": `(SELECT 'publisher', publishers.id, publishers.name, count(*)
+        FROM matched
+        INNER JOIN publishers ON publishers.id = matched.publisher_id
+        WHERE type_ok AND author_ok
+        GROUP BY publishers.id, publishers.name
+        ORDER BY count(*) DESC, publishers.name ASC
+        LIMIT %d)`,
+}
+
+// Facets counts the books matching filter per value of every requested
+// field in a single query. Authors and publishers are limited to the limit
+// most frequent ones; every book type in use is returned.
+func (repo BookRepository) Facets(filter BookFilter, fields []string, limit int) (Facets, error) {
+	facets := make(Facets, len(fields))
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		query, ok := bookFacetQueries[field]
+		if _, seen := facets[field]; !ok || seen {
+			continue
+		}
+
+		facets[field] = make([]FacetValue, 0)
+		if strings.Contains(query, "%d") {
+			query = fmt.Sprintf(query, limit)
+		}
+		parts = append(parts, query)
+	}
+
+	if len(parts) == 0 {
+		return facets, nil
+	}
+
+	query := `WITH matched AS (
+        SELECT books.id, books.types, books.publisher_id,
+            ` + bookTypesCondition + ` AS type_ok,
+            ` + bookAuthorCondition + ` AS author_ok,
+            ` + bookPublisherCondition + ` AS publisher_ok
+        FROM books
+        WHERE ` + bookNameCondition + `
+        AND ` + bookQueryCondition + `
+        AND ` + bookRatingCondition + `
+    )
+    ` + strings.Join(parts, "\n    UNION ALL\n    ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.withSearchSettings(ctx, filter, func(q queryer) error {
+		rows, err := q.QueryContext(ctx, query, filter.args()...)
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var field string
+			var value FacetValue
+
+			if err = rows.Scan(&field, &value.ID, &value.Name, &value.Count); err != nil {
+				return err
+			}
+
+			if field == "type" {
+				def, known := bookTypes.lookup(BookType(value.ID))
+				if !known {
+					continue
+				}
+				value.Slug, value.Name = def.Slug, def.Name
+			}
+
+			facets[field] = append(facets[field], value)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if types, ok := facets["type"]; ok {
+		sort.Slice(types, func(i, j int) bool {
+			if types[i].Count != types[j].Count {
+				return types[i].Count > types[j].Count
+			}
+			return types[i].ID < types[j].ID
+		})
+	}
+
+	return facets, nil
+}
+
+func (repo MockBookRepository) Facets(filter BookFilter, fields []string, limit int) (Facets, error) {
+	return Facets{}, nil
+}
@@ -0,0 +1,322 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDuplicateBookType = errors.New("duplicate book type")
+
+type BookTypeDefinition struct {
+	ID        int32     `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	ParentID  int32     `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+func (def *BookTypeDefinition) ValidateBookTypeDefinition(v *validator.Validator) {
+	v.Check(def.Slug != "", "slug", "must be provided")
+	v.Check(len(def.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(def.Slug, validator.SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+	v.Check(def.Name != "", "name", "must be provided")
+	v.Check(len(def.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	if def.ParentID != 0 {
+		_, known := bookTypes.lookup(BookType(def.ParentID))
+		v.Check(known, "parent_id", "must reference an existing book type")
+		v.Check(def.ID == 0 || !bookTypes.isDescendant(BookType(def.ParentID), BookType(def.ID)), "parent_id", "must not be the book type itself or one of its descendants")
+	}
+}
+
+// Slug returns the slug of a known book type or an empty string.
+func (t BookType) Slug() string {
+	def, _ := bookTypes.lookup(t)
+	return def.Slug
+}
+
+// bookTypeCache is the in-memory copy of the book_types table which backs
+// BookType (un)marshalling. It is refreshed by BookTypeRepository.Load.
+type bookTypeCache struct {
+	mu       sync.RWMutex
+	byID     map[BookType]BookTypeDefinition
+	byKey    map[string]BookType
+	children map[BookType][]BookType
+}
+
+var bookTypes = &bookTypeCache{}
+
+func (c *bookTypeCache) set(defs []*BookTypeDefinition) {
+	byID := make(map[BookType]BookTypeDefinition, len(defs))
+	byKey := make(map[string]BookType, 2*len(defs))
+	children := make(map[BookType][]BookType)
+
+	for _, def := range defs {
+		id := BookType(def.ID)
+		byID[id] = *def
+		byKey[strings.ToLower(def.Name)] = id
+		byKey[def.Slug] = id
+		if def.ParentID != 0 {
+			children[BookType(def.ParentID)] = append(children[BookType(def.ParentID)], id)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.byID = byID
+	c.byKey = byKey
+	c.children = children
+}
+
+func (c *bookTypeCache) lookup(t BookType) (BookTypeDefinition, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	def, ok := c.byID[t]
+	return def, ok
+}
+
+func (c *bookTypeCache) find(s string) BookType {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.byKey[strings.ToLower(strings.TrimSpace(s))]
+}
+
+// descendants returns t followed by every book type below it in the hierarchy.
+func (c *bookTypeCache) descendants(t BookType) []BookType {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []BookType{t}
+	seen := map[BookType]bool{t: true}
+
+	for i := 0; i < len(result); i++ {
+		for _, child := range c.children[result[i]] {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+			}
+		}
+	}
+
+	return result
+}
+
+func (c *bookTypeCache) isDescendant(t, ancestor BookType) bool {
+	for _, d := range c.descendants(ancestor) {
+		if d == t {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBookTypes turns every requested type into a comma separated group of
+// itself and its descendants, the format GetAll expects for its type filter.
+func expandBookTypes(types []BookType) []string {
+	groups := make([]string, 0, len(types))
+	for _, t := range types {
+		ids := make([]string, 0)
+		for _, d := range bookTypes.descendants(t) {
+			ids = append(ids, strconv.Itoa(int(d)))
+		}
+		groups = append(groups, strings.Join(ids, ","))
+	}
+	return groups
+}
+
+type BookTypeRepository struct {
+	DB *sql.DB
+}
+
+// Load reads the whole taxonomy and replaces the cache used for BookType
+// marshalling and validation.
+func (repo BookTypeRepository) Load() error {
+	defs, err := repo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	bookTypes.set(defs)
+	return nil
+}
+
+func (repo BookTypeRepository) GetAll() ([]*BookTypeDefinition, error) {
+	query := `SELECT id, created_at, slug, name, coalesce(parent_id, 0), version
+    FROM book_types
+    ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	defs := make([]*BookTypeDefinition, 0)
+
+	for rows.Next() {
+		var def BookTypeDefinition
+		err = rows.Scan(&def.ID, &def.CreatedAt, &def.Slug, &def.Name, &def.ParentID, &def.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		defs = append(defs, &def)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+func (repo BookTypeRepository) Insert(def *BookTypeDefinition) error {
+	query := `INSERT INTO book_types (slug, name, parent_id) VALUES ($1, $2, $3) RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, def.Slug, def.Name, nullableID(int64(def.ParentID))).Scan(&def.ID, &def.CreatedAt, &def.Version)
+	if err != nil {
+		if isUniqueViolation(err, "book_types_slug_key") {
+			return ErrDuplicateBookType
+		}
+		return err
+	}
+
+	return repo.Load()
+}
+
+func (repo BookTypeRepository) Get(id int64) (*BookTypeDefinition, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, slug, name, coalesce(parent_id, 0), version FROM book_types WHERE id = $1`
+
+	var def BookTypeDefinition
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
+		&def.ID,
+		&def.CreatedAt,
+		&def.Slug,
+		&def.Name,
+		&def.ParentID,
+		&def.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &def, nil
+}
+
+func (repo BookTypeRepository) Update(def *BookTypeDefinition) error {
+	query := `UPDATE book_types SET slug = $1, name = $2, parent_id = $3, version = version + 1
+    WHERE id = $4 AND version = $5
+    RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{def.Slug, def.Name, nullableID(int64(def.ParentID)), def.ID, def.Version}
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&def.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "book_types_slug_key"):
+			return ErrDuplicateBookType
+		default:
+			return err
+		}
+	}
+
+	return repo.Load()
+}
+
+// Delete refuses to remove a book type that still has children or is
+// assigned to any book.
+func (repo BookTypeRepository) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inUse bool
+	err := repo.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE $1::text = ANY(types))`, id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return ErrRecordInUse
+	}
+
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM book_types WHERE id = $1`, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return repo.Load()
+}
+
+type MockBookTypeRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockBookTypeRepository) Load() error {
+	return nil
+}
+
+func (repo MockBookTypeRepository) GetAll() ([]*BookTypeDefinition, error) {
+	return nil, nil
+}
+
+func (repo MockBookTypeRepository) Insert(def *BookTypeDefinition) error {
+	return nil
+}
+
+func (repo MockBookTypeRepository) Get(id int64) (*BookTypeDefinition, error) {
+	return nil, nil
+}
+
+func (repo MockBookTypeRepository) Update(def *BookTypeDefinition) error {
+	return nil
+}
+
+func (repo MockBookTypeRepository) Delete(id int64) error {
+	return nil
+}
@@ -0,0 +1,229 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateBarcode = errors.New("duplicate barcode")
+	ErrUnknownCopy      = errors.New("unknown copy")
+)
+
+var CopyConditions = []string{"new", "good", "fair", "poor", "damaged"}
+
+// Copy is a physical copy of a book held by the library.
+type Copy struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	Barcode   string    `json:"barcode"`
+	Condition string    `json:"condition"`
+	Location  string    `json:"location,omitempty"`
+	OnLoan    bool      `json:"on_loan"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+// CopyCounts is shown on a single book.
+type CopyCounts struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+}
+
+func (bookCopy *Copy) ValidateCopy(v *validator.Validator) {
+	v.Check(strings.TrimSpace(bookCopy.Barcode) != "", "barcode", "must be provided")
+	v.Check(len(bookCopy.Barcode) <= 64, "barcode", "must not be more than 64 bytes long")
+	v.Check(validator.PermittedValue(bookCopy.Condition, CopyConditions...), "condition", "must be one of new, good, fair, poor or damaged")
+	v.Check(len(bookCopy.Location) <= 200, "location", "must not be more than 200 bytes long")
+}
+
+// bookCopyColumns count the copies of a book and those free to be checked
+// out by anyone. They follow bookColumns where a single book is read.
+const bookCopyColumns = `(SELECT count(*) FROM copies WHERE copies.book_id = books.id),
+    (SELECT count(*) FROM copies WHERE copies.book_id = books.id AND ` + freeCopyCondition + `)`
+
+func (counts *CopyCounts) scanTargets() []any {
+	return []any{&counts.Total, &counts.Available}
+}
+
+type CopyRepository struct {
+	DB *sql.DB
+}
+
+const copyColumns = `copies.id, copies.book_id, copies.barcode, copies.condition, copies.location, copies.created_at, copies.version,
+    EXISTS (SELECT 1 FROM loans WHERE loans.copy_id = copies.id AND loans.returned_at IS NULL)`
+
+func (bookCopy *Copy) scanTargets() []any {
+	return []any{&bookCopy.ID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Condition, &bookCopy.Location, &bookCopy.CreatedAt, &bookCopy.Version, &bookCopy.OnLoan}
+}
+
+func (repo CopyRepository) GetAllForBook(bookID int64) ([]*Copy, error) {
+	query := `SELECT ` + copyColumns + `
+    FROM copies
+    WHERE book_id = $1
+    ORDER BY barcode ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	copies := make([]*Copy, 0)
+
+	for rows.Next() {
+		var bookCopy Copy
+		if err = rows.Scan(bookCopy.scanTargets()...); err != nil {
+			return nil, err
+		}
+
+		copies = append(copies, &bookCopy)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+func (repo CopyRepository) getOne(where string, arg any) (*Copy, error) {
+	query := `SELECT ` + copyColumns + ` FROM copies WHERE ` + where
+
+	var bookCopy Copy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, arg).Scan(bookCopy.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &bookCopy, nil
+}
+
+func (repo CopyRepository) Get(id int64) (*Copy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return repo.getOne(`id = $1`, id)
+}
+
+func (repo CopyRepository) GetByBarcode(barcode string) (*Copy, error) {
+	return repo.getOne(`barcode = $1`, barcode)
+}
+
+func (repo CopyRepository) Insert(bookCopy *Copy) error {
+	query := `INSERT INTO copies (book_id, barcode, condition, location)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Location).Scan(&bookCopy.ID, &bookCopy.CreatedAt, &bookCopy.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "copies_barcode_key"):
+			return ErrDuplicateBarcode
+		case isForeignKeyViolation(err):
+			return ErrUnknownBook
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo CopyRepository) Update(bookCopy *Copy) error {
+	query := `UPDATE copies SET barcode = $1, condition = $2, location = $3, version = version + 1
+    WHERE id = $4 AND version = $5
+    RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, bookCopy.Barcode, bookCopy.Condition, bookCopy.Location, bookCopy.ID, bookCopy.Version).Scan(&bookCopy.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "copies_barcode_key"):
+			return ErrDuplicateBarcode
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete refuses to remove a copy which has ever been lent out, so that the
+// loan history stays intact.
+func (repo CopyRepository) Delete(id int64) error {
+	query := `DELETE FROM copies WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type MockCopyRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockCopyRepository) GetAllForBook(bookID int64) ([]*Copy, error) {
+	return nil, nil
+}
+
+func (repo MockCopyRepository) Get(id int64) (*Copy, error) {
+	return nil, nil
+}
+
+func (repo MockCopyRepository) GetByBarcode(barcode string) (*Copy, error) {
+	return nil, nil
+}
+
+func (repo MockCopyRepository) Insert(bookCopy *Copy) error {
+	return nil
+}
+
+func (repo MockCopyRepository) Update(bookCopy *Copy) error {
+	return nil
+}
+
+func (repo MockCopyRepository) Delete(id int64) error {
+	return nil
+}
@@ -2,15 +2,56 @@ package data
 
 import (
 	"bookworm.snnafi.dev/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	SortBy       string
 	SortSafelist []string
+	// Cursor switches to keyset pagination when non-nil. A zero Cursor
+	// requests the first page.
+	Cursor *Cursor
+}
+
+// Cursor is the position of a row in a keyset paginated listing: the value
+// of the sort column and the id used as a tie-breaker.
+type Cursor struct {
+	SortBy   string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return &Cursor{}, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err = json.Unmarshal(js, &c); err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
 }
 
 func (f Filters) ValidateFilters(v *validator.Validator) {
@@ -20,6 +61,10 @@ func (f Filters) ValidateFilters(v *validator.Validator) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.PermittedValue(f.SortBy, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.Cursor != nil && f.Cursor.ID != 0 {
+		v.Check(f.Cursor.SortBy == f.SortBy, "cursor", "was issued for a different sort value")
+	}
 }
 
 func (f Filters) sortColumn() string {
@@ -39,6 +84,56 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// keysetCondition returns the condition selecting the rows after (or before,
+// when paging backwards) the cursor, using placeholders $n and $n+1 for the
+// sort value and id. column is the sort expression and idColumn the
+// qualified id column used as a tie-breaker.
+func (f Filters) keysetCondition(column, idColumn string, n int) (string, []any) {
+	if f.Cursor == nil || f.Cursor.ID == 0 {
+		return "TRUE", nil
+	}
+
+	op, idOp := ">", ">"
+	if f.sortDirection() == "DESC" {
+		op = "<"
+	}
+
+	if f.Cursor.Backward {
+		op, idOp = flipComparison(op), "<"
+	}
+
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND %[5]s %[3]s $%[6]d))",
+		column, op, idOp, n, idColumn, n+1)
+
+	return condition, []any{f.Cursor.Value, f.Cursor.ID}
+}
+
+// keysetOrder is the ORDER BY clause matching keysetCondition. Backward pages
+// are read in reverse and flipped back by the caller.
+func (f Filters) keysetOrder(column, idColumn string) string {
+	direction, idDirection := f.sortDirection(), "ASC"
+
+	if f.Cursor != nil && f.Cursor.Backward {
+		direction, idDirection = flipDirection(direction), "DESC"
+	}
+
+	return fmt.Sprintf("%s %s, %s %s", column, direction, idColumn, idDirection)
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+func flipDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -48,11 +143,13 @@ func (f Filters) offset() int {
 }
 
 type MetaData struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetaDta(totalRecords, page, pageSize int) MetaData {
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{SortBy: "name", Value: "Dune", ID: 42},
+		{SortBy: "-name", Value: "", ID: 1, Backward: true},
+		{SortBy: "author", Value: `Le Guin, "Ursula" K.`, ID: 7},
+		{SortBy: "-id", Value: "9001", ID: 9001, Backward: true},
+	}
+
+	for _, want := range tests {
+		got, err := DecodeCursor(want.Encode())
+		if err != nil {
+			t.Errorf("DecodeCursor(%+v.Encode()) returned error %v", want, err)
+			continue
+		}
+
+		if *got != want {
+			t.Errorf("DecodeCursor(%+v.Encode()) = %+v", want, *got)
+		}
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cursor  string
+		want    *Cursor
+		wantErr error
+	}{
+		{"empty requests the first page", "", &Cursor{}, nil},
+		{"not base64", "!!!", nil, ErrInvalidCursor},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("dune")), nil, ErrInvalidCursor},
+		{"missing id", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"name","v":"Dune"}`)), nil, ErrInvalidCursor},
+		{"negative id", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"name","v":"Dune","id":-1}`)), nil, ErrInvalidCursor},
+		{"valid", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"name","v":"Dune","id":3}`)), &Cursor{SortBy: "name", Value: "Dune", ID: 3}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.cursor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	safelist := []string{"id", "name", "-id", "-name"}
+
+	tests := []struct {
+		name      string
+		sortBy    string
+		cursor    *Cursor
+		want      string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			name:      "offset pagination",
+			sortBy:    "name",
+			cursor:    nil,
+			want:      "TRUE",
+			wantOrder: "name ASC, books.id ASC",
+		},
+		{
+			name:      "first page",
+			sortBy:    "name",
+			cursor:    &Cursor{},
+			want:      "TRUE",
+			wantOrder: "name ASC, books.id ASC",
+		},
+		{
+			name:      "ascending forward",
+			sortBy:    "name",
+			cursor:    &Cursor{SortBy: "name", Value: "Dune", ID: 5},
+			want:      "(name > $3 OR (name = $3 AND books.id > $4))",
+			wantOrder: "name ASC, books.id ASC",
+			wantArgs:  []any{"Dune", int64(5)},
+		},
+		{
+			name:      "ascending backward",
+			sortBy:    "name",
+			cursor:    &Cursor{SortBy: "name", Value: "Dune", ID: 5, Backward: true},
+			want:      "(name < $3 OR (name = $3 AND books.id < $4))",
+			wantOrder: "name DESC, books.id DESC",
+			wantArgs:  []any{"Dune", int64(5)},
+		},
+		{
+			name:      "descending forward",
+			sortBy:    "-name",
+			cursor:    &Cursor{SortBy: "-name", Value: "Dune", ID: 5},
+			want:      "(name < $3 OR (name = $3 AND books.id > $4))",
+			wantOrder: "name DESC, books.id ASC",
+			wantArgs:  []any{"Dune", int64(5)},
+		},
+		{
+			name:      "descending backward",
+			sortBy:    "-name",
+			cursor:    &Cursor{SortBy: "-name", Value: "Dune", ID: 5, Backward: true},
+			want:      "(name > $3 OR (name = $3 AND books.id < $4))",
+			wantOrder: "name ASC, books.id DESC",
+			wantArgs:  []any{"Dune", int64(5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{SortBy: tt.sortBy, SortSafelist: safelist, Cursor: tt.cursor}
+
+			got, args := f.keysetCondition(f.sortColumn(), "books.id", 3)
+			if got != tt.want {
+				t.Errorf("condition = %q; want %q", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v; want %v", args, tt.wantArgs)
+			}
+
+			if order := f.keysetOrder(f.sortColumn(), "books.id"); order != tt.wantOrder {
+				t.Errorf("order = %q; want %q", order, tt.wantOrder)
+			}
+		})
+	}
+}
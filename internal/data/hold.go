@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateHold = errors.New("duplicate hold")
+	ErrHoldInactive  = errors.New("hold inactive")
+	ErrCopyOnHold    = errors.New("copy on hold")
+	ErrNoCopies      = errors.New("no copies")
+)
+
+const (
+	HoldStatusWaiting   = "waiting"
+	HoldStatusReady     = "ready"
+	HoldStatusFulfilled = "fulfilled"
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired"
+)
+
+// Hold is a borrower's place in the queue for a book. Once a copy comes back
+// it is set aside for the first waiting hold, which then has until ExpiresAt
+// to check it out.
+type Hold struct {
+	ID        int64      `json:"id"`
+	BookID    int64      `json:"book_id"`
+	BookName  string     `json:"book_name"`
+	UserID    int64      `json:"user_id"`
+	Status    string     `json:"status"`
+	Position  int        `json:"position,omitempty"`
+	CopyID    *int64     `json:"copy_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Version   int32      `json:"version"`
+}
+
+func (hold *Hold) Active() bool {
+	return hold.Status == HoldStatusWaiting || hold.Status == HoldStatusReady
+}
+
+type HoldRepository struct {
+	DB *sql.DB
+}
+
+// Position is only meaningful while waiting; it is one more than the number
+// of waiting holds on the same book placed before this one.
+const holdColumns = `holds.id, holds.book_id, books.name, holds.user_id, holds.status,
+    CASE WHEN holds.status = 'waiting' THEN
+        (SELECT count(*) FROM holds AS ahead WHERE ahead.book_id = holds.book_id AND ahead.status = 'waiting'
+            AND (ahead.created_at, ahead.id) < (holds.created_at, holds.id)) + 1
+    ELSE 0 END,
+    holds.copy_id, holds.created_at, holds.ready_at, holds.expires_at, holds.version`
+
+const holdJoins = `INNER JOIN books ON books.id = holds.book_id`
+
+func (hold *Hold) scanTargets() []any {
+	return []any{&hold.ID, &hold.BookID, &hold.BookName, &hold.UserID, &hold.Status, &hold.Position,
+		&hold.CopyID, &hold.CreatedAt, &hold.ReadyAt, &hold.ExpiresAt, &hold.Version}
+}
+
+// freeCopyCondition matches copies that are neither on loan nor set aside
+// for a hold.
+const freeCopyCondition = `NOT EXISTS (SELECT 1 FROM loans WHERE loans.copy_id = copies.id AND loans.returned_at IS NULL)
+    AND NOT EXISTS (SELECT 1 FROM holds WHERE holds.copy_id = copies.id AND holds.status = 'ready')`
+
+// assignHolds advances the queue of a book, setting free copies aside for the
+// waiting holds in the order they were placed. Both rows are locked so that
+// a concurrent checkout of the copy is serialised with the assignment.
+func assignHolds(ctx context.Context, tx *sql.Tx, bookID int64, window time.Duration) error {
+	for {
+		var holdID int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM holds
+        WHERE book_id = $1 AND status = 'waiting'
+        ORDER BY created_at ASC, id ASC
+        LIMIT 1
+        FOR UPDATE`, bookID).Scan(&holdID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		var copyID int64
+		err = tx.QueryRowContext(ctx, `SELECT id FROM copies
+        WHERE book_id = $1 AND `+freeCopyCondition+`
+        ORDER BY id ASC
+        LIMIT 1
+        FOR UPDATE`, bookID).Scan(&copyID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE holds
+        SET status = 'ready', copy_id = $1, ready_at = NOW(), expires_at = NOW() + make_interval(secs => $2), version = version + 1
+        WHERE id = $3`, copyID, window.Seconds(), holdID)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (repo HoldRepository) getAll(where string, arg any) ([]*Hold, error) {
+	query := `SELECT ` + holdColumns + `
+    FROM holds
+    ` + holdJoins + `
+    WHERE ` + where + `
+    ORDER BY holds.created_at ASC, holds.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	holds := make([]*Hold, 0)
+
+	for rows.Next() {
+		var hold Hold
+		if err = rows.Scan(hold.scanTargets()...); err != nil {
+			return nil, err
+		}
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// GetAllForUser lists the active holds of a user.
+func (repo HoldRepository) GetAllForUser(userID int64) ([]*Hold, error) {
+	return repo.getAll(`holds.user_id = $1 AND holds.status IN ('waiting', 'ready')`, userID)
+}
+
+// GetQueue lists the active holds on a book in queue order.
+func (repo HoldRepository) GetQueue(bookID int64) ([]*Hold, error) {
+	return repo.getAll(`holds.book_id = $1 AND holds.status IN ('waiting', 'ready')`, bookID)
+}
+
+func (repo HoldRepository) Get(id int64) (*Hold, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + holdColumns + ` FROM holds ` + holdJoins + ` WHERE holds.id = $1`
+
+	var hold Hold
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(hold.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+// Insert queues a hold at the back of the line for its book. If a copy is
+// free it is set aside straight away.
+func (repo HoldRepository) Insert(hold *Hold, window time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var copies int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM copies WHERE book_id = $1`, hold.BookID).Scan(&copies)
+	if err != nil {
+		return err
+	}
+
+	if copies == 0 {
+		return ErrNoCopies
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO holds (book_id, user_id) VALUES ($1, $2) RETURNING id`, hold.BookID, hold.UserID).Scan(&hold.ID)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "holds_active_user_book_idx"):
+			return ErrDuplicateHold
+		case isForeignKeyViolation(err):
+			return ErrUnknownBook
+		default:
+			return err
+		}
+	}
+
+	err = assignHolds(ctx, tx, hold.BookID, window)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT `+holdColumns+` FROM holds `+holdJoins+` WHERE holds.id = $1`, hold.ID).Scan(hold.scanTargets()...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Cancel takes a hold out of the queue. A copy set aside for it passes on to
+// the next hold in line.
+func (repo HoldRepository) Cancel(hold *Hold, window time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `UPDATE holds SET status = 'cancelled', version = version + 1
+    WHERE id = $1 AND version = $2 AND status IN ('waiting', 'ready')
+    RETURNING status, version`, hold.ID, hold.Version).Scan(&hold.Status, &hold.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	err = assignHolds(ctx, tx, hold.BookID, window)
+	if err != nil {
+		return err
+	}
+
+	hold.Position = 0
+
+	return tx.Commit()
+}
+
+// ExpireStale expires the ready holds whose window has passed and advances
+// the queue of every book with a free copy and someone waiting for it. It
+// returns the number of holds expired.
+func (repo HoldRepository) ExpireStale(window time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, `UPDATE holds SET status = 'expired', version = version + 1
+    WHERE status = 'ready' AND expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `SELECT DISTINCT holds.book_id FROM holds
+    WHERE holds.status = 'waiting'
+    AND EXISTS (SELECT 1 FROM copies WHERE copies.book_id = holds.book_id AND `+freeCopyCondition+`)`)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	var bookIDs []int64
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+
+		bookIDs = append(bookIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, bookID := range bookIDs {
+		if err = repo.advance(ctx, bookID, window); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(expired), nil
+}
+
+func (repo HoldRepository) advance(ctx context.Context, bookID int64, window time.Duration) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = assignHolds(ctx, tx, bookID, window)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type MockHoldRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockHoldRepository) GetAllForUser(userID int64) ([]*Hold, error) {
+	return nil, nil
+}
+
+func (repo MockHoldRepository) GetQueue(bookID int64) ([]*Hold, error) {
+	return nil, nil
+}
+
+func (repo MockHoldRepository) Get(id int64) (*Hold, error) {
+	return nil, nil
+}
+
+func (repo MockHoldRepository) Insert(hold *Hold, window time.Duration) error {
+	return nil
+}
+
+func (repo MockHoldRepository) Cancel(hold *Hold, window time.Duration) error {
+	return nil
+}
+
+func (repo MockHoldRepository) ExpireStale(window time.Duration) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrCopyOnLoan   = errors.New("copy on loan")
+	ErrLoanReturned = errors.New("loan already returned")
+	ErrRenewalLimit = errors.New("renewal limit reached")
+	ErrUnknownUser  = errors.New("unknown user")
+)
+
+type Loan struct {
+	ID           int64      `json:"id"`
+	CopyID       int64      `json:"copy_id"`
+	Barcode      string     `json:"barcode"`
+	BookID       int64      `json:"book_id"`
+	BookName     string     `json:"book_name"`
+	UserID       int64      `json:"user_id"`
+	UserName     string     `json:"user_name"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+	Renewals     int        `json:"renewals"`
+	Version      int32      `json:"version"`
+}
+
+type LoanRepository struct {
+	DB *sql.DB
+}
+
+const loanColumns = `loans.id, loans.copy_id, copies.barcode, books.id, books.name, users.id, users.name,
+    loans.checked_out_at, loans.due_at, loans.returned_at, loans.renewals, loans.version`
+
+const loanJoins = `INNER JOIN copies ON copies.id = loans.copy_id
+    INNER JOIN books ON books.id = copies.book_id
+    INNER JOIN users ON users.id = loans.user_id`
+
+func (loan *Loan) scanTargets() []any {
+	return []any{&loan.ID, &loan.CopyID, &loan.Barcode, &loan.BookID, &loan.BookName, &loan.UserID, &loan.UserName,
+		&loan.CheckedOutAt, &loan.DueAt, &loan.ReturnedAt, &loan.Renewals, &loan.Version}
+}
+
+// getLoan reads a loan, locking it for the rest of the transaction if lock
+// is set.
+func getLoan(ctx context.Context, q rowQueryer, id int64, lock bool) (*Loan, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans ` + loanJoins + ` WHERE loans.id = $1`
+	if lock {
+		query += ` FOR UPDATE OF loans`
+	}
+
+	var loan Loan
+
+	err := q.QueryRowContext(ctx, query, id).Scan(loan.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+func (repo LoanRepository) Get(id int64) (*Loan, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getLoan(ctx, repo.DB, id, false)
+}
+
+// Checkout lends a copy to a user until dueAt. The copy row is locked for
+// the duration of the transaction so that two checkouts of the same copy
+// are serialised and the second one sees the first loan. A copy set aside
+// for a hold can only go to the holder, whose holds on the book are then
+// fulfilled.
+func (repo LoanRepository) Checkout(copyID, userID int64, dueAt time.Time, holdWindow time.Duration) (*Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	var onLoan bool
+	err = tx.QueryRowContext(ctx, `SELECT book_id, EXISTS (SELECT 1 FROM loans WHERE copy_id = copies.id AND returned_at IS NULL)
+    FROM copies WHERE id = $1 FOR UPDATE`, copyID).Scan(&bookID, &onLoan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnknownCopy
+		}
+		return nil, err
+	}
+
+	if onLoan {
+		return nil, ErrCopyOnLoan
+	}
+
+	var holderID int64
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM holds WHERE copy_id = $1 AND status = 'ready' FOR UPDATE`, copyID).Scan(&holderID)
+	switch {
+	case err == nil && holderID != userID:
+		return nil, ErrCopyOnHold
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO loans (copy_id, user_id, due_at) VALUES ($1, $2, $3) RETURNING id`, copyID, userID, dueAt).Scan(&id)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "loans_active_copy_idx"):
+			return nil, ErrCopyOnLoan
+		case isForeignKeyViolation(err):
+			return nil, ErrUnknownUser
+		default:
+			return nil, err
+		}
+	}
+
+	// The borrower may have been holding a different copy of the same book,
+	// which goes back to the queue.
+	_, err = tx.ExecContext(ctx, `UPDATE holds SET status = 'fulfilled', version = version + 1
+    WHERE book_id = $1 AND user_id = $2 AND status IN ('waiting', 'ready')`, bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assignHolds(ctx, tx, bookID, holdWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	loan, err := getLoan(ctx, tx, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// Renew moves the due date of an open loan out by period, counting from the
+// current due date or from now if the loan is already overdue.
+func (repo LoanRepository) Renew(id int64, period time.Duration, maxRenewals int) (*Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	loan, err := getLoan(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case loan.ReturnedAt != nil:
+		return nil, ErrLoanReturned
+	case loan.Renewals >= maxRenewals:
+		return nil, ErrRenewalLimit
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE loans
+    SET due_at = greatest(due_at, NOW()) + make_interval(secs => $1), renewals = renewals + 1, version = version + 1
+    WHERE id = $2
+    RETURNING due_at, renewals, version`, period.Seconds(), id).Scan(&loan.DueAt, &loan.Renewals, &loan.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// Return closes an open loan. The copy is set aside for the first hold on
+// the book, if any, or otherwise becomes available again.
+func (repo LoanRepository) Return(id int64, holdWindow time.Duration) (*Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	loan, err := getLoan(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if loan.ReturnedAt != nil {
+		return nil, ErrLoanReturned
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE loans SET returned_at = NOW(), version = version + 1
+    WHERE id = $1
+    RETURNING returned_at, version`, id).Scan(&loan.ReturnedAt, &loan.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = assignHolds(ctx, tx, loan.BookID, holdWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// GetOverdue lists the open loans past their due date.
+func (repo LoanRepository) GetOverdue(filters Filters) ([]*Loan, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), %s
+    FROM loans
+    %s
+    WHERE loans.returned_at IS NULL AND loans.due_at < NOW()
+    ORDER BY loans.%s %s, loans.id ASC
+    LIMIT $1 OFFSET $2`,
+		loanColumns, loanJoins, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	loans := make([]*Loan, 0)
+
+	for rows.Next() {
+		var loan Loan
+		if err = rows.Scan(append([]any{&totalRecords}, loan.scanTargets()...)...); err != nil {
+			return nil, MetaData{}, err
+		}
+
+		loans = append(loans, &loan)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return loans, metadata, nil
+}
+
+type MockLoanRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockLoanRepository) Get(id int64) (*Loan, error) {
+	return nil, nil
+}
+
+func (repo MockLoanRepository) Checkout(copyID, userID int64, dueAt time.Time, holdWindow time.Duration) (*Loan, error) {
+	return nil, nil
+}
+
+func (repo MockLoanRepository) Renew(id int64, period time.Duration, maxRenewals int) (*Loan, error) {
+	return nil, nil
+}
+
+func (repo MockLoanRepository) Return(id int64, holdWindow time.Duration) (*Loan, error) {
+	return nil, nil
+}
+
+func (repo MockLoanRepository) GetOverdue(filters Filters) ([]*Loan, MetaData, error) {
+	return nil, MetaData{}, nil
+}
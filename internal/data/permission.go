@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"slices"
+	"time"
+)
+
+const (
+	PermissionBooksRead  = "books:read"
+	PermissionBooksWrite = "books:write"
+	PermissionLoansWrite = "loans:write"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionRepository struct {
+	DB *sql.DB
+}
+
+func (repo PermissionRepository) GetAllForUser(userID int64) (Permissions, error) {
+	query := `SELECT permissions.code
+    FROM permissions
+    INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+    WHERE users_permissions.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+const addPermissionsQuery = `INSERT INTO users_permissions
+    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING`
+
+func (repo PermissionRepository) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, addPermissionsQuery, userID, pq.Array(codes))
+	return err
+}
+
+type MockPermissionRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockPermissionRepository) GetAllForUser(userID int64) (Permissions, error) {
+	return nil, nil
+}
+
+func (repo MockPermissionRepository) AddForUser(userID int64, codes ...string) error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrDuplicatePublisher = errors.New("duplicate publisher")
+	ErrUnknownPublisher   = errors.New("unknown publisher")
+)
+
+type Publisher struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Website   string    `json:"website,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+func (publisher *Publisher) ValidatePublisher(v *validator.Validator) {
+	v.Check(publisher.Name != "", "name", "must be provided")
+	v.Check(len(publisher.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(publisher.Website) <= 2048, "website", "must not be more than 2048 bytes long")
+}
+
+type PublisherRepository struct {
+	DB *sql.DB
+}
+
+func (repo PublisherRepository) GetAll(name string, filters Filters) ([]*Publisher, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), id, created_at, name, website, version
+    FROM publishers
+    WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $2 OFFSET $3`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	publishers := make([]*Publisher, 0)
+
+	for rows.Next() {
+		var publisher Publisher
+		err = rows.Scan(&totalRecords, &publisher.ID, &publisher.CreatedAt, &publisher.Name, &publisher.Website, &publisher.Version)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		publishers = append(publishers, &publisher)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return publishers, metadata, nil
+}
+
+func (repo PublisherRepository) Insert(publisher *Publisher) error {
+	query := `INSERT INTO publishers (name, website) VALUES ($1, $2) RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, publisher.Name, publisher.Website).Scan(&publisher.ID, &publisher.CreatedAt, &publisher.Version)
+	if err != nil {
+		if isUniqueViolation(err, "publishers_name_unique_idx") {
+			return ErrDuplicatePublisher
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo PublisherRepository) Get(id int64) (*Publisher, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, website, version FROM publishers WHERE id = $1`
+
+	var publisher Publisher
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
+		&publisher.ID,
+		&publisher.CreatedAt,
+		&publisher.Name,
+		&publisher.Website,
+		&publisher.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &publisher, nil
+}
+
+// Update also renames the publisher on the books linked to it, so that their
+// free-text publisher and search stay in step.
+func (repo PublisherRepository) Update(publisher *Publisher) error {
+	query := `UPDATE publishers SET name = $1, website = $2, version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, publisher.Name, publisher.Website, publisher.ID, publisher.Version).Scan(&publisher.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "publishers_name_unique_idx"):
+			return ErrDuplicatePublisher
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE books SET publisher = $1, version = version + 1
+    WHERE publisher_id = $2 AND publisher <> $1`, publisher.Name, publisher.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo PublisherRepository) Delete(id int64) error {
+	query := `DELETE FROM publishers WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRecordInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type MockPublisherRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockPublisherRepository) GetAll(name string, filters Filters) ([]*Publisher, MetaData, error) {
+	return nil, MetaData{}, nil
+}
+
+func (repo MockPublisherRepository) Insert(publisher *Publisher) error {
+	return nil
+}
+
+func (repo MockPublisherRepository) Get(id int64) (*Publisher, error) {
+	return nil, nil
+}
+
+func (repo MockPublisherRepository) Update(publisher *Publisher) error {
+	return nil
+}
+
+func (repo MockPublisherRepository) Delete(id int64) error {
+	return nil
+}
@@ -0,0 +1,390 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	ReadingStatusWantToRead = "want_to_read"
+	ReadingStatusReading    = "reading"
+	ReadingStatusFinished   = "finished"
+	ReadingStatusAbandoned  = "abandoned"
+)
+
+var ReadingStatuses = []string{ReadingStatusWantToRead, ReadingStatusReading, ReadingStatusFinished, ReadingStatusAbandoned}
+
+// dateLayout is the format of StartedOn and FinishedOn, which are calendar
+// dates without a time of day.
+const dateLayout = "2006-01-02"
+
+// ReadingProgress is where a user stands with a book.
+type ReadingProgress struct {
+	UserID      int64     `json:"-"`
+	BookID      int64     `json:"book_id"`
+	Status      string    `json:"status"`
+	CurrentPage int       `json:"current_page"`
+	Percent     float64   `json:"percent"`
+	StartedOn   string    `json:"started_on,omitempty"`
+	FinishedOn  string    `json:"finished_on,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int32     `json:"version"`
+	Book        *Book     `json:"book,omitempty"`
+}
+
+// ApplyStatus changes the status and fills in what it implies: starting to
+// read sets the start date, finishing sets the finish date and moves to the
+// last page. Dates already set are kept, except that only finished books
+// have a finish date.
+func (progress *ReadingProgress) ApplyStatus(status string, today time.Time) {
+	progress.Status = status
+
+	if status != ReadingStatusFinished {
+		progress.FinishedOn = ""
+	}
+
+	switch status {
+	case ReadingStatusReading:
+		if progress.StartedOn == "" {
+			progress.StartedOn = today.Format(dateLayout)
+		}
+	case ReadingStatusFinished:
+		if progress.StartedOn == "" {
+			progress.StartedOn = today.Format(dateLayout)
+		}
+		if progress.FinishedOn == "" {
+			progress.FinishedOn = today.Format(dateLayout)
+		}
+		if progress.Book != nil && progress.Book.PageCount > 0 {
+			progress.CurrentPage = progress.Book.PageCount
+		}
+	}
+}
+
+func (progress *ReadingProgress) ValidateReadingProgress(v *validator.Validator) {
+	v.Check(validator.PermittedValue(progress.Status, ReadingStatuses...), "status", "must be one of want_to_read, reading, finished or abandoned")
+	v.Check(progress.CurrentPage >= 0, "current_page", "must not be negative")
+
+	if progress.Book != nil && progress.Book.PageCount > 0 {
+		v.Check(progress.CurrentPage <= progress.Book.PageCount, "current_page", "must not be more than the page count of the book")
+	}
+
+	started, startedErr := time.Parse(dateLayout, progress.StartedOn)
+	if progress.StartedOn != "" {
+		v.Check(startedErr == nil, "started_on", "must be a date in the format YYYY-MM-DD")
+	}
+
+	finished, finishedErr := time.Parse(dateLayout, progress.FinishedOn)
+	if progress.FinishedOn != "" {
+		v.Check(finishedErr == nil, "finished_on", "must be a date in the format YYYY-MM-DD")
+	}
+
+	if startedErr == nil && finishedErr == nil {
+		v.Check(!finished.Before(started), "finished_on", "must not be before started_on")
+	}
+}
+
+func (progress *ReadingProgress) calculatePercent() {
+	switch {
+	case progress.Status == ReadingStatusFinished:
+		progress.Percent = 100
+	case progress.Book != nil && progress.Book.PageCount > 0:
+		percent := float64(progress.CurrentPage) / float64(progress.Book.PageCount) * 100
+		progress.Percent = math.Round(math.Min(percent, 100)*10) / 10
+	default:
+		progress.Percent = 0
+	}
+}
+
+// ReadingStats summarises the books a user finished in a year. Pages read
+// counts the pages of those books, so a book counts in the year it was
+// finished.
+type ReadingStats struct {
+	Year          int               `json:"year"`
+	BooksFinished int               `json:"books_finished"`
+	PagesRead     int               `json:"pages_read"`
+	ByType        []ReadingTypeStat `json:"by_type"`
+}
+
+type ReadingTypeStat struct {
+	ID            int32  `json:"id"`
+	Slug          string `json:"slug"`
+	Name          string `json:"name"`
+	BooksFinished int    `json:"books_finished"`
+	PagesRead     int    `json:"pages_read"`
+}
+
+type ReadingProgressRepository struct {
+	DB *sql.DB
+}
+
+const readingProgressColumns = `reading_progress.user_id, reading_progress.book_id, reading_progress.status, reading_progress.current_page,
+    coalesce(to_char(reading_progress.started_on, 'YYYY-MM-DD'), ''), coalesce(to_char(reading_progress.finished_on, 'YYYY-MM-DD'), ''),
+    reading_progress.updated_at, reading_progress.version`
+
+func (progress *ReadingProgress) scanTargets() []any {
+	return []any{&progress.UserID, &progress.BookID, &progress.Status, &progress.CurrentPage,
+		&progress.StartedOn, &progress.FinishedOn, &progress.UpdatedAt, &progress.Version}
+}
+
+func (repo ReadingProgressRepository) GetAllForUser(userID int64, status string, filters Filters) ([]*ReadingProgress, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), %s, %s
+    FROM reading_progress
+    INNER JOIN books ON books.id = reading_progress.book_id
+    WHERE reading_progress.user_id = $1
+    AND (reading_progress.status = $2 OR $2 = '')
+    ORDER BY %s %s, reading_progress.book_id ASC
+    LIMIT $3 OFFSET $4`,
+		readingProgressColumns, bookColumns, readingProgressSortExpression(filters.sortColumn()), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := make([]*ReadingProgress, 0)
+	books := make([]*Book, 0)
+
+	for rows.Next() {
+		progress := ReadingProgress{Book: &Book{}}
+
+		err = rows.Scan(append(append([]any{&totalRecords}, progress.scanTargets()...), progress.Book.scanTargets()...)...)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		progress.calculatePercent()
+		entries = append(entries, &progress)
+		books = append(books, progress.Book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	if err = loadBookAuthors(ctx, repo.DB, books...); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+func readingProgressSortExpression(column string) string {
+	switch column {
+	case "name":
+		return "books.name"
+	default:
+		return "reading_progress." + column
+	}
+}
+
+func (repo ReadingProgressRepository) Get(userID, bookID int64) (*ReadingProgress, error) {
+	query := `SELECT ` + readingProgressColumns + `, ` + bookColumns + `
+    FROM reading_progress
+    INNER JOIN books ON books.id = reading_progress.book_id
+    WHERE reading_progress.user_id = $1 AND reading_progress.book_id = $2`
+
+	progress := ReadingProgress{Book: &Book{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, userID, bookID).Scan(append(progress.scanTargets(), progress.Book.scanTargets()...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err = loadBookAuthors(ctx, repo.DB, progress.Book); err != nil {
+		return nil, err
+	}
+
+	progress.calculatePercent()
+
+	return &progress, nil
+}
+
+func (repo ReadingProgressRepository) Insert(progress *ReadingProgress) error {
+	query := `INSERT INTO reading_progress (user_id, book_id, status, current_page, started_on, finished_on)
+    VALUES ($1, $2, $3, $4, nullif($5, '')::date, nullif($6, '')::date)
+    RETURNING updated_at, version`
+
+	args := []any{progress.UserID, progress.BookID, progress.Status, progress.CurrentPage, progress.StartedOn, progress.FinishedOn}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&progress.UpdatedAt, &progress.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "reading_progress_pkey"):
+			return ErrEditConflict
+		case isForeignKeyViolation(err):
+			return ErrUnknownBook
+		default:
+			return err
+		}
+	}
+
+	progress.calculatePercent()
+
+	return nil
+}
+
+func (repo ReadingProgressRepository) Update(progress *ReadingProgress) error {
+	query := `UPDATE reading_progress
+    SET status = $1, current_page = $2, started_on = nullif($3, '')::date, finished_on = nullif($4, '')::date,
+        updated_at = NOW(), version = version + 1
+    WHERE user_id = $5 AND book_id = $6 AND version = $7
+    RETURNING updated_at, version`
+
+	args := []any{progress.Status, progress.CurrentPage, progress.StartedOn, progress.FinishedOn, progress.UserID, progress.BookID, progress.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&progress.UpdatedAt, &progress.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	progress.calculatePercent()
+
+	return nil
+}
+
+func (repo ReadingProgressRepository) Delete(userID, bookID int64) error {
+	query := `DELETE FROM reading_progress WHERE user_id = $1 AND book_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, userID, bookID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Stats summarises the books userID finished in year. The totals and the
+// breakdown per book type come from a single query; the row with a zero
+// type carries the totals.
+func (repo ReadingProgressRepository) Stats(userID int64, year int) (*ReadingStats, error) {
+	query := `WITH finished AS (
+        SELECT books.id, books.types, greatest(books.page_count, reading_progress.current_page) AS pages
+        FROM reading_progress
+        INNER JOIN books ON books.id = reading_progress.book_id
+        WHERE reading_progress.user_id = $1
+        AND reading_progress.status = 'finished'
+        AND reading_progress.finished_on >= make_date($2, 1, 1)
+        AND reading_progress.finished_on < make_date($2 + 1, 1, 1)
+    )
+    SELECT 0, count(*), coalesce(sum(pages), 0) FROM finished
+    UNION ALL
+    SELECT type_id::integer, count(*), coalesce(sum(pages), 0)
+    FROM finished, unnest(finished.types) AS type_id
+    GROUP BY type_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, userID, year)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stats := &ReadingStats{Year: year, ByType: make([]ReadingTypeStat, 0)}
+
+	for rows.Next() {
+		var stat ReadingTypeStat
+		if err = rows.Scan(&stat.ID, &stat.BooksFinished, &stat.PagesRead); err != nil {
+			return nil, err
+		}
+
+		if stat.ID == 0 {
+			stats.BooksFinished, stats.PagesRead = stat.BooksFinished, stat.PagesRead
+			continue
+		}
+
+		def, known := bookTypes.lookup(BookType(stat.ID))
+		if !known {
+			continue
+		}
+
+		stat.Slug, stat.Name = def.Slug, def.Name
+		stats.ByType = append(stats.ByType, stat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stats.ByType, func(i, j int) bool {
+		if stats.ByType[i].BooksFinished != stats.ByType[j].BooksFinished {
+			return stats.ByType[i].BooksFinished > stats.ByType[j].BooksFinished
+		}
+		return stats.ByType[i].ID < stats.ByType[j].ID
+	})
+
+	return stats, nil
+}
+
+type MockReadingProgressRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockReadingProgressRepository) GetAllForUser(userID int64, status string, filters Filters) ([]*ReadingProgress, MetaData, error) {
+	return nil, MetaData{}, nil
+}
+
+func (repo MockReadingProgressRepository) Get(userID, bookID int64) (*ReadingProgress, error) {
+	return nil, nil
+}
+
+func (repo MockReadingProgressRepository) Insert(progress *ReadingProgress) error {
+	return nil
+}
+
+func (repo MockReadingProgressRepository) Update(progress *ReadingProgress) error {
+	return nil
+}
+
+func (repo MockReadingProgressRepository) Delete(userID, bookID int64) error {
+	return nil
+}
+
+func (repo MockReadingProgressRepository) Stats(userID int64, year int) (*ReadingStats, error) {
+	return nil, nil
+}
@@ -1,33 +1,218 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrRecordInUse    = errors.New("record in use")
 )
 
 type Repositories struct {
 	BookRepo interface {
-		GetAll(name string, types []BookType, filters Filters) ([]*Book, MetaData, error)
+		GetAll(filter BookFilter, filters Filters) ([]*Book, MetaData, error)
+		Export(ctx context.Context, filter BookFilter, fn func(books []*Book) error) error
+		SuggestName(filter BookFilter) (string, error)
+		Facets(filter BookFilter, fields []string, limit int) (Facets, error)
 		Insert(book *Book) error
+		InsertBatch(books []*Book) ([]error, error)
+		CheckBatch(books []*Book) ([]error, error)
 		Get(id int64) (*Book, error)
+		GetByISBN(isbn string) (*Book, error)
 		Update(book *Book) error
 		Delete(id int64) error
 	}
+	AuthorRepo interface {
+		GetAll(name string, filters Filters) ([]*Author, MetaData, error)
+		Insert(author *Author) error
+		Get(id int64) (*Author, error)
+		Update(author *Author) error
+		Delete(id int64) error
+	}
+	BookTypeRepo interface {
+		Load() error
+		GetAll() ([]*BookTypeDefinition, error)
+		Insert(def *BookTypeDefinition) error
+		Get(id int64) (*BookTypeDefinition, error)
+		Update(def *BookTypeDefinition) error
+		Delete(id int64) error
+	}
+	UserRepo interface {
+		Insert(user *User) error
+		Register(user *User, codes []string, activationTTL time.Duration) (*Token, error)
+		GetByEmail(email string) (*User, error)
+		Update(user *User) error
+		GetForToken(scope, tokenPlaintext string) (*User, error)
+	}
+	TokenRepo interface {
+		New(userID int64, ttl time.Duration, scope string) (*Token, error)
+		Insert(token *Token) error
+		DeleteAllForUser(scope string, userID int64) error
+	}
+	PermissionRepo interface {
+		GetAllForUser(userID int64) (Permissions, error)
+		AddForUser(userID int64, codes ...string) error
+	}
+	PublisherRepo interface {
+		GetAll(name string, filters Filters) ([]*Publisher, MetaData, error)
+		Insert(publisher *Publisher) error
+		Get(id int64) (*Publisher, error)
+		Update(publisher *Publisher) error
+		Delete(id int64) error
+	}
+	SuggestionRepo interface {
+		Get(field, prefix string, limit int) ([]string, error)
+	}
+	ShelfRepo interface {
+		GetAllForUser(userID int64) ([]*Shelf, error)
+		Get(id int64) (*Shelf, error)
+		GetBySlug(slug string) (*Shelf, error)
+		Insert(shelf *Shelf) error
+		Update(shelf *Shelf) error
+		Delete(id int64) error
+		GetBooks(shelfID int64, filters Filters) ([]*ShelfBook, MetaData, error)
+		AddBook(shelfID, bookID int64, position int) error
+		RemoveBook(shelfID, bookID int64) error
+		ReorderBooks(shelfID int64, bookIDs []int64) error
+	}
+	ReviewRepo interface {
+		GetAllForBook(bookID int64, filters Filters) ([]*Review, MetaData, error)
+		Get(id int64) (*Review, error)
+		Insert(review *Review) error
+		Update(review *Review) error
+		Delete(review *Review) error
+	}
+	ReadingProgressRepo interface {
+		GetAllForUser(userID int64, status string, filters Filters) ([]*ReadingProgress, MetaData, error)
+		Get(userID, bookID int64) (*ReadingProgress, error)
+		Insert(progress *ReadingProgress) error
+		Update(progress *ReadingProgress) error
+		Delete(userID, bookID int64) error
+		Stats(userID int64, year int) (*ReadingStats, error)
+	}
+	CopyRepo interface {
+		GetAllForBook(bookID int64) ([]*Copy, error)
+		Get(id int64) (*Copy, error)
+		GetByBarcode(barcode string) (*Copy, error)
+		Insert(bookCopy *Copy) error
+		Update(bookCopy *Copy) error
+		Delete(id int64) error
+	}
+	LoanRepo interface {
+		Get(id int64) (*Loan, error)
+		Checkout(copyID, userID int64, dueAt time.Time, holdWindow time.Duration) (*Loan, error)
+		Renew(id int64, period time.Duration, maxRenewals int) (*Loan, error)
+		Return(id int64, holdWindow time.Duration) (*Loan, error)
+		GetOverdue(filters Filters) ([]*Loan, MetaData, error)
+	}
+	HoldRepo interface {
+		GetAllForUser(userID int64) ([]*Hold, error)
+		GetQueue(bookID int64) ([]*Hold, error)
+		Get(id int64) (*Hold, error)
+		Insert(hold *Hold, window time.Duration) error
+		Cancel(hold *Hold, window time.Duration) error
+		ExpireStale(window time.Duration) (int, error)
+	}
 }
 
 func NewRepositories(db *sql.DB) Repositories {
 	return Repositories{
-		BookRepo: BookRepository{DB: db},
+		BookRepo:            BookRepository{DB: db},
+		AuthorRepo:          AuthorRepository{DB: db},
+		BookTypeRepo:        BookTypeRepository{DB: db},
+		PublisherRepo:       PublisherRepository{DB: db},
+		UserRepo:            UserRepository{DB: db},
+		TokenRepo:           TokenRepository{DB: db},
+		PermissionRepo:      PermissionRepository{DB: db},
+		SuggestionRepo:      SuggestionRepository{DB: db},
+		ShelfRepo:           ShelfRepository{DB: db},
+		ReviewRepo:          ReviewRepository{DB: db},
+		ReadingProgressRepo: ReadingProgressRepository{DB: db},
+		CopyRepo:            CopyRepository{DB: db},
+		LoanRepo:            LoanRepository{DB: db},
+		HoldRepo:            HoldRepository{DB: db},
 	}
 }
 
 func NewMockRepositories(db *sql.DB) Repositories {
 	return Repositories{
-		BookRepo: MockBookRepository{DB: db},
+		BookRepo:            MockBookRepository{DB: db},
+		AuthorRepo:          MockAuthorRepository{DB: db},
+		BookTypeRepo:        MockBookTypeRepository{DB: db},
+		PublisherRepo:       MockPublisherRepository{DB: db},
+		UserRepo:            MockUserRepository{DB: db},
+		TokenRepo:           MockTokenRepository{DB: db},
+		PermissionRepo:      MockPermissionRepository{DB: db},
+		SuggestionRepo:      MockSuggestionRepository{DB: db},
+		ShelfRepo:           MockShelfRepository{DB: db},
+		ReviewRepo:          MockReviewRepository{DB: db},
+		ReadingProgressRepo: MockReadingProgressRepository{DB: db},
+		CopyRepo:            MockCopyRepository{DB: db},
+		LoanRepo:            MockLoanRepository{DB: db},
+		HoldRepo:            MockHoldRepository{DB: db},
 	}
 }
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so that helpers can run
+// inside or outside a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// rowQueryer is the single row counterpart of queryer.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && (constraint == "" || pqErr.Constraint == constraint)
+	}
+	return false
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+	return false
+}
+
+// nullableID maps the zero value of an optional foreign key to SQL NULL.
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+// existingIDs reports which of ids are present in table. The table name is
+// never user supplied.
+func existingIDs(ctx context.Context, q queryer, table string, ids []int64) (map[int64]bool, error) {
+	found := make(map[int64]bool, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT id FROM `+table+` WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found[id] = true
+	}
+
+	return found, rows.Err()
+}
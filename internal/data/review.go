@@ -0,0 +1,262 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	UserID    int64     `json:"-"`
+	UserName  string    `json:"user_name"`
+	Rating    int       `json:"rating"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func (review *Review) ValidateReview(v *validator.Validator) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Text) <= 10_000, "text", "must not be more than 10000 bytes long")
+}
+
+type ReviewRepository struct {
+	DB *sql.DB
+}
+
+const reviewColumns = `reviews.id, reviews.book_id, reviews.user_id, users.name, reviews.rating, reviews.text,
+    reviews.created_at, reviews.updated_at, reviews.version`
+
+func (review *Review) scanTargets() []any {
+	return []any{&review.ID, &review.BookID, &review.UserID, &review.UserName, &review.Rating, &review.Text,
+		&review.CreatedAt, &review.UpdatedAt, &review.Version}
+}
+
+// lockBookForRating locks the book row for the rest of the transaction so
+// that concurrent review changes recalculate its rating one after another,
+// each seeing the reviews committed before it.
+func lockBookForRating(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM books WHERE id = $1 FOR UPDATE`, bookID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownBook
+		}
+		return err
+	}
+	return nil
+}
+
+// refreshBookRating recalculates the denormalised rating of a book from its
+// reviews. The book version is left alone since the book itself is unchanged.
+func refreshBookRating(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	query := `UPDATE books SET (average_rating, ratings_count) = (
+        SELECT coalesce(round(avg(rating), 2), 0), count(*) FROM reviews WHERE book_id = $1
+    )
+    WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, bookID)
+	return err
+}
+
+func (repo ReviewRepository) GetAllForBook(bookID int64, filters Filters) ([]*Review, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), %s
+    FROM reviews
+    INNER JOIN users ON users.id = reviews.user_id
+    WHERE reviews.book_id = $1
+    ORDER BY reviews.%s %s, reviews.id ASC
+    LIMIT $2 OFFSET $3`,
+		reviewColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, bookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := make([]*Review, 0)
+
+	for rows.Next() {
+		var review Review
+		if err = rows.Scan(append([]any{&totalRecords}, review.scanTargets()...)...); err != nil {
+			return nil, MetaData{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+func (repo ReviewRepository) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + reviewColumns + `
+    FROM reviews
+    INNER JOIN users ON users.id = reviews.user_id
+    WHERE reviews.id = $1`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(review.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+func (repo ReviewRepository) Insert(review *Review) error {
+	query := `INSERT INTO reviews (user_id, book_id, rating, text)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockBookForRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	args := []any{review.UserID, review.BookID, review.Rating, review.Text}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		if isUniqueViolation(err, "reviews_user_book_key") {
+			return ErrDuplicateReview
+		}
+		return err
+	}
+
+	if err = refreshBookRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo ReviewRepository) Update(review *Review) error {
+	query := `UPDATE reviews SET rating = $1, text = $2, updated_at = NOW(), version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockBookForRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, review.Rating, review.Text, review.ID, review.Version).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	if err = refreshBookRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo ReviewRepository) Delete(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockBookForRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, review.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err = refreshBookRating(ctx, tx, review.BookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type MockReviewRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockReviewRepository) GetAllForBook(bookID int64, filters Filters) ([]*Review, MetaData, error) {
+	return nil, MetaData{}, nil
+}
+
+func (repo MockReviewRepository) Get(id int64) (*Review, error) {
+	return nil, nil
+}
+
+func (repo MockReviewRepository) Insert(review *Review) error {
+	return nil
+}
+
+func (repo MockReviewRepository) Update(review *Review) error {
+	return nil
+}
+
+func (repo MockReviewRepository) Delete(review *Review) error {
+	return nil
+}
@@ -0,0 +1,458 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateShelf     = errors.New("duplicate shelf")
+	ErrDuplicateShelfBook = errors.New("book already on shelf")
+	ErrUnknownBook        = errors.New("unknown book")
+	ErrInvalidShelfOrder  = errors.New("invalid shelf order")
+)
+
+// Shelf is a named, ordered list of books owned by a user. It is private
+// unless Public is set, in which case anyone knowing Slug can read it.
+type Shelf struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Public    bool      `json:"public"`
+	Slug      string    `json:"slug,omitempty"`
+	BookCount int       `json:"book_count"`
+	Version   int32     `json:"version"`
+}
+
+// ShelfBook is a book together with its place on a shelf.
+type ShelfBook struct {
+	*Book
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+func (shelf *Shelf) ValidateShelf(v *validator.Validator) {
+	v.Check(strings.TrimSpace(shelf.Name) != "", "name", "must be provided")
+	v.Check(len(shelf.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+// publicSlug returns the slug to store for the shelf, generating one the
+// first time a shelf is shared. Unsharing drops the slug so that a link
+// handed out earlier stops working.
+func (shelf *Shelf) publicSlug() (sql.NullString, error) {
+	if !shelf.Public {
+		return sql.NullString{}, nil
+	}
+
+	if shelf.Slug == "" {
+		randomBytes := make([]byte, 10)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return sql.NullString{}, err
+		}
+		shelf.Slug = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	}
+
+	return sql.NullString{String: shelf.Slug, Valid: true}, nil
+}
+
+type ShelfRepository struct {
+	DB *sql.DB
+}
+
+const shelfColumns = `shelves.id, shelves.created_at, shelves.user_id, shelves.name, coalesce(shelves.public_slug, ''), shelves.version,
+    (SELECT count(*) FROM shelf_books WHERE shelf_books.shelf_id = shelves.id)`
+
+func (shelf *Shelf) scanTargets() []any {
+	return []any{&shelf.ID, &shelf.CreatedAt, &shelf.UserID, &shelf.Name, &shelf.Slug, &shelf.Version, &shelf.BookCount}
+}
+
+func (repo ShelfRepository) getOne(where string, arg any) (*Shelf, error) {
+	query := `SELECT ` + shelfColumns + ` FROM shelves WHERE ` + where
+
+	var shelf Shelf
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, arg).Scan(shelf.scanTargets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	shelf.Public = shelf.Slug != ""
+
+	return &shelf, nil
+}
+
+func (repo ShelfRepository) GetAllForUser(userID int64) ([]*Shelf, error) {
+	query := `SELECT ` + shelfColumns + `
+    FROM shelves
+    WHERE user_id = $1
+    ORDER BY lower(name) ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	shelves := make([]*Shelf, 0)
+
+	for rows.Next() {
+		var shelf Shelf
+		if err = rows.Scan(shelf.scanTargets()...); err != nil {
+			return nil, err
+		}
+
+		shelf.Public = shelf.Slug != ""
+		shelves = append(shelves, &shelf)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shelves, nil
+}
+
+func (repo ShelfRepository) Get(id int64) (*Shelf, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return repo.getOne(`id = $1`, id)
+}
+
+// GetBySlug returns a shared shelf.
+func (repo ShelfRepository) GetBySlug(slug string) (*Shelf, error) {
+	if slug == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	return repo.getOne(`public_slug = $1`, slug)
+}
+
+func (repo ShelfRepository) Insert(shelf *Shelf) error {
+	slug, err := shelf.publicSlug()
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO shelves (user_id, name, public_slug) VALUES ($1, $2, $3) RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = repo.DB.QueryRowContext(ctx, query, shelf.UserID, shelf.Name, slug).Scan(&shelf.ID, &shelf.CreatedAt, &shelf.Version)
+	if err != nil {
+		if isUniqueViolation(err, "shelves_user_name_unique_idx") {
+			return ErrDuplicateShelf
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (repo ShelfRepository) Update(shelf *Shelf) error {
+	slug, err := shelf.publicSlug()
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE shelves SET name = $1, public_slug = $2, version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = repo.DB.QueryRowContext(ctx, query, shelf.Name, slug, shelf.ID, shelf.Version).Scan(&shelf.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "shelves_user_name_unique_idx"):
+			return ErrDuplicateShelf
+		default:
+			return err
+		}
+	}
+
+	shelf.Slug = slug.String
+
+	return nil
+}
+
+func (repo ShelfRepository) Delete(id int64) error {
+	query := `DELETE FROM shelves WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func shelfBookSortExpression(column string) string {
+	switch column {
+	case "position", "added_at":
+		return "shelf_books." + column
+	default:
+		return "books." + column
+	}
+}
+
+// GetBooks lists the books on a shelf, by default in shelf order.
+func (repo ShelfRepository) GetBooks(shelfID int64, filters Filters) ([]*ShelfBook, MetaData, error) {
+	query := fmt.Sprintf(`SELECT
+    count(*) OVER(), %s, shelf_books.position, shelf_books.added_at
+    FROM shelf_books
+    INNER JOIN books ON books.id = shelf_books.book_id
+    WHERE shelf_books.shelf_id = $1
+    ORDER BY %s %s, books.id ASC
+    LIMIT $2 OFFSET $3`,
+		bookColumns, shelfBookSortExpression(filters.sortColumn()), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, shelfID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := make([]*ShelfBook, 0)
+	books := make([]*Book, 0)
+
+	for rows.Next() {
+		entry := ShelfBook{Book: &Book{}}
+
+		err = rows.Scan(append(append([]any{&totalRecords}, entry.scanTargets()...), &entry.Position, &entry.AddedAt)...)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		entries = append(entries, &entry)
+		books = append(books, entry.Book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	if err = loadBookAuthors(ctx, repo.DB, books...); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetaDta(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// AddBook puts a book on a shelf at position, moving the books from there on
+// one place down. A position of zero or past the end appends the book.
+func (repo ShelfRepository) AddBook(shelfID, bookID int64, position int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the shelf serialises concurrent changes to its order.
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT (SELECT count(*) FROM shelf_books WHERE shelf_id = $1) FROM shelves WHERE id = $1 FOR UPDATE`, shelfID).Scan(&count)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if position < 1 || position > count {
+		position = count + 1
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE shelf_books SET position = position + 1 WHERE shelf_id = $1 AND position >= $2`, shelfID, position)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO shelf_books (shelf_id, book_id, position) VALUES ($1, $2, $3)`, shelfID, bookID, position)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "shelf_books_pkey"):
+			return ErrDuplicateShelfBook
+		case isForeignKeyViolation(err):
+			return ErrUnknownBook
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveBook takes a book off a shelf and closes the gap it leaves.
+func (repo ShelfRepository) RemoveBook(shelfID, bookID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT id FROM shelves WHERE id = $1 FOR UPDATE`, shelfID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx, `DELETE FROM shelf_books WHERE shelf_id = $1 AND book_id = $2 RETURNING position`, shelfID, bookID).Scan(&position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE shelf_books SET position = position - 1 WHERE shelf_id = $1 AND position > $2`, shelfID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderBooks puts the books on a shelf in the order of bookIDs, which must
+// list every book on the shelf exactly once.
+func (repo ShelfRepository) ReorderBooks(shelfID int64, bookIDs []int64) error {
+	seen := make(map[int64]bool, len(bookIDs))
+	for _, id := range bookIDs {
+		if seen[id] {
+			return ErrInvalidShelfOrder
+		}
+		seen[id] = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT (SELECT count(*) FROM shelf_books WHERE shelf_id = $1) FROM shelves WHERE id = $1 FOR UPDATE`, shelfID).Scan(&count)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if count != len(bookIDs) {
+		return ErrInvalidShelfOrder
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE shelf_books SET position = wanted.position
+    FROM unnest($2::bigint[]) WITH ORDINALITY AS wanted(book_id, position)
+    WHERE shelf_books.shelf_id = $1 AND shelf_books.book_id = wanted.book_id`, shelfID, pq.Array(bookIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if int(rowsAffected) != count {
+		return ErrInvalidShelfOrder
+	}
+
+	return tx.Commit()
+}
+
+type MockShelfRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockShelfRepository) GetAllForUser(userID int64) ([]*Shelf, error) {
+	return nil, nil
+}
+
+func (repo MockShelfRepository) Get(id int64) (*Shelf, error) {
+	return nil, nil
+}
+
+func (repo MockShelfRepository) GetBySlug(slug string) (*Shelf, error) {
+	return nil, nil
+}
+
+func (repo MockShelfRepository) Insert(shelf *Shelf) error {
+	return nil
+}
+
+func (repo MockShelfRepository) Update(shelf *Shelf) error {
+	return nil
+}
+
+func (repo MockShelfRepository) Delete(id int64) error {
+	return nil
+}
+
+func (repo MockShelfRepository) GetBooks(shelfID int64, filters Filters) ([]*ShelfBook, MetaData, error) {
+	return nil, MetaData{}, nil
+}
+
+func (repo MockShelfRepository) AddBook(shelfID, bookID int64, position int) error {
+	return nil
+}
+
+func (repo MockShelfRepository) RemoveBook(shelfID, bookID int64) error {
+	return nil
+}
+
+func (repo MockShelfRepository) ReorderBooks(shelfID int64, bookIDs []int64) error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SuggestionFields maps the fields suggestions can be drawn from to the
+// table holding their names. Every table has a GIN index on
+// to_tsvector('simple', name).
+var SuggestionFields = map[string]string{
+	"name":      "books",
+	"author":    "authors",
+	"publisher": "publishers",
+}
+
+type SuggestionRepository struct {
+	DB *sql.DB
+}
+
+// prefixTSQuery turns free text typed into a search box into a tsquery which
+// requires every word, the last one possibly incomplete. Anything but letters
+// and digits is dropped so the result is always valid tsquery syntax.
+func prefixTSQuery(prefix string) string {
+	words := strings.FieldsFunc(prefix, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// Get returns at most limit distinct names from field starting with prefix,
+// names that begin with the whole prefix first and shorter names before
+// longer ones. The prefix is compared literally, so % and _ are not
+// wildcards.
+func (repo SuggestionRepository) Get(field, prefix string, limit int) ([]string, error) {
+	suggestions := make([]string, 0)
+
+	table, ok := SuggestionFields[field]
+	if !ok {
+		return suggestions, nil
+	}
+
+	tsquery := prefixTSQuery(prefix)
+	if tsquery == "" {
+		return suggestions, nil
+	}
+
+	query := `SELECT name FROM ` + table + `
+    WHERE to_tsvector('simple', name) @@ to_tsquery('simple', $1)
+    GROUP BY name
+    ORDER BY starts_with(lower(name), lower($2)) DESC, length(name) ASC, name ASC
+    LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, tsquery, strings.TrimSpace(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+type MockSuggestionRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockSuggestionRepository) Get(field, prefix string, limit int) ([]string, error) {
+	return nil, nil
+}
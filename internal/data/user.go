@@ -0,0 +1,280 @@
+package data
+
+import (
+	"bookworm.snnafi.dev/internal/validator"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// AnonymousUser is put on the request context when no credentials were sent.
+var AnonymousUser = &User{}
+
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Version   int32     `json:"-"`
+}
+
+func (user *User) IsAnonymous() bool {
+	return user == AnonymousUser
+}
+
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+
+	return nil
+}
+
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func (user *User) ValidateUser(v *validator.Validator) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	// A missing hash means the caller forgot to set the password, which is a
+	// programming error rather than a client mistake.
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+type UserRepository struct {
+	DB *sql.DB
+}
+
+const insertUserQuery = `INSERT INTO users (name, email, password_hash, activated)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, version`
+
+func (repo UserRepository) Insert(user *User) error {
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, insertUserQuery, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Register inserts a new user together with their permissions and an
+// activation token, so that a failure part way leaves nothing behind.
+func (repo UserRepository) Register(user *User, codes []string, activationTTL time.Duration) (*Token, error) {
+	token, err := generateToken(0, activationTTL, ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	err = tx.QueryRowContext(ctx, insertUserQuery, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, addPermissionsQuery, user.ID, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+
+	token.UserID = user.ID
+
+	_, err = tx.ExecContext(ctx, insertTokenQuery, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (repo UserRepository) GetByEmail(email string) (*User, error) {
+	query := `SELECT id, created_at, name, email, password_hash, activated, version
+    FROM users
+    WHERE email = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (repo UserRepository) Update(user *User) error {
+	query := `UPDATE users
+    SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+    WHERE id = $5 AND version = $6
+    RETURNING version`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err, "users_email_key"):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the owner of a token which is still valid for scope.
+func (repo UserRepository) GetForToken(scope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+    FROM users
+    INNER JOIN tokens ON users.id = tokens.user_id
+    WHERE tokens.hash = $1
+    AND tokens.scope = $2
+    AND tokens.expiry > $3`
+
+	args := []any{tokenHash[:], scope, time.Now()}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+type MockUserRepository struct {
+	DB *sql.DB
+}
+
+func (repo MockUserRepository) Insert(user *User) error {
+	return nil
+}
+
+func (repo MockUserRepository) Register(user *User, codes []string, activationTTL time.Duration) (*Token, error) {
+	return nil, nil
+}
+
+func (repo MockUserRepository) GetByEmail(email string) (*User, error) {
+	return nil, nil
+}
+
+func (repo MockUserRepository) Update(user *User) error {
+	return nil
+}
+
+func (repo MockUserRepository) GetForToken(scope, tokenPlaintext string) (*User, error) {
+	return nil, nil
+}
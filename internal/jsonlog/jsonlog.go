@@ -2,17 +2,24 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,41 +27,175 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
-type Logger struct {
+// ParseLevel accepts the level names in any case.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level %q (must be debug, info, warn, error, fatal or off)", s)
+}
+
+type Format int8
+
+const (
+	FormatJSON Format = iota
+	FormatText
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "text":
+		return FormatText, nil
+	default:
+		return 0, fmt.Errorf("unknown log format %q (must be json or text)", s)
+	}
+}
+
+// Option configures a Logger created by New.
+type Option func(*core)
+
+func WithFormat(format Format) Option {
+	return func(c *core) { c.format = format }
+}
+
+// WithStackTraces adds a stack trace to entries at error level and above.
+func WithStackTraces(enabled bool) Option {
+	return func(c *core) { c.traces = enabled }
+}
+
+// WithSampling writes at most perSecond entries with the same level and
+// message in any one second, dropping the rest. The first entry written for
+// that message in the following second carries the number dropped. Zero
+// disables sampling; fatal entries and those of an Unsampled logger are never
+// dropped.
+func WithSampling(perSecond int) Option {
+	return func(c *core) {
+		if perSecond > 0 {
+			c.sampler = newSampler(perSecond)
+		}
+	}
+}
+
+// core is shared by a logger and every child created from it with With.
+type core struct {
 	out      io.Writer
-	minLevel Level
 	mu       sync.Mutex
+	minLevel atomic.Int32
+	format   Format
+	traces   bool
+	sampler  *sampler
+}
+
+type Logger struct {
+	core      *core
+	fields    map[string]any
+	unsampled bool
+}
+
+func New(out io.Writer, minLevel Level, opts ...Option) *Logger {
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return &Logger{core: c}
 }
 
-func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+// With returns a child logger which adds the given key/value pairs to every
+// entry. The child shares its parent's output and level.
+func (l *Logger) With(keysAndValues ...any) *Logger {
+	fields := make(map[string]any, len(l.fields)+len(keysAndValues)/2)
+	for k, v := range l.fields {
+		fields[k] = v
 	}
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			fields[key] = keysAndValues[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+
+	return &Logger{core: l.core, fields: fields, unsampled: l.unsampled}
+}
+
+// Unsampled returns a child logger whose entries are never dropped by
+// sampling, for logs such as the access log where every entry matters.
+func (l *Logger) Unsampled() *Logger {
+	return &Logger{core: l.core, fields: l.fields, unsampled: true}
+}
+
+// SetLevel changes the minimum level of the logger and all of its children
+// while they are in use.
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+func (l *Logger) print(level Level, message string, properties map[string]any) (int, error) {
+	// Fatal entries explain why the process exits, so they are written
+	// whatever the minimum level.
+	if level < l.Level() && level < LevelFatal {
 		return 0, nil
 	}
 
+	var dropped int
+	if l.core.sampler != nil && !l.unsampled && level < LevelFatal {
+		var ok bool
+		if ok, dropped = l.core.sampler.allow(level, message); !ok {
+			return 0, nil
+		}
+	}
+
+	if len(l.fields) > 0 || dropped > 0 {
+		merged := make(map[string]any, len(l.fields)+len(properties)+1)
+		for k, v := range l.fields {
+			merged[k] = v
+		}
+		for k, v := range properties {
+			merged[k] = v
+		}
+		if dropped > 0 {
+			merged["sampled_dropped"] = dropped
+		}
+		properties = merged
+	}
+
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
@@ -62,36 +203,146 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Properties: properties,
 	}
 
-	if level >= LevelError {
+	if l.core.traces && level >= LevelError {
 		aux.Trace = string(debug.Stack())
 	}
 
 	var line []byte
 
-	line, err := json.Marshal(aux)
-	if err != nil {
-		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+	if l.core.format == FormatText {
+		line = formatText(aux.Time, aux.Level, aux.Message, aux.Properties, aux.Trace)
+	} else {
+		var err error
+		line, err = json.Marshal(aux)
+		if err != nil {
+			line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+		}
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
 
-	return l.out.Write(append(line, '\n'))
+	return l.core.out.Write(append(line, '\n'))
+}
+
+// formatText renders an entry as a single logfmt style line, with the
+// properties sorted by key.
+func formatText(t, level, message string, properties map[string]any, trace string) []byte {
+	var b strings.Builder
+
+	b.WriteString(t)
+	b.WriteByte(' ')
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(message)
+
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b.WriteByte(' ')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(textValue(properties[k]))
+	}
+
+	if trace != "" {
+		b.WriteString(" trace=")
+		b.WriteString(strconv.Quote(trace))
+	}
+
+	return []byte(b.String())
+}
+
+func textValue(v any) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelError, string(message), nil)
 }
 
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, string(message), properties)
+func (l *Logger) PrintDebug(message string, properties map[string]any) {
+	l.print(LevelDebug, message, properties)
+}
+
+func (l *Logger) PrintInfo(message string, properties map[string]any) {
+	l.print(LevelInfo, message, properties)
+}
+
+func (l *Logger) PrintWarn(message string, properties map[string]any) {
+	l.print(LevelWarn, message, properties)
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
+func (l *Logger) PrintError(err error, properties map[string]any) {
 	l.print(LevelError, err.Error(), properties)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) PrintFatal(err error, properties map[string]any) {
 	l.print(LevelFatal, err.Error(), properties)
 	os.Exit(1)
 }
+
+// sampler counts entries per level and message within the current second.
+// Counts from the previous second are kept just long enough to report how
+// many entries were dropped.
+type sampler struct {
+	perSecond int
+	now       func() time.Time
+	mu        sync.Mutex
+	second    int64
+	counts    map[string]int
+	dropped   map[string]int
+}
+
+func newSampler(perSecond int) *sampler {
+	return &sampler{perSecond: perSecond, now: time.Now, counts: map[string]int{}, dropped: map[string]int{}}
+}
+
+// allow reports whether an entry may be written and, if so, how many
+// entries for the same message were dropped before it.
+func (s *sampler) allow(level Level, message string) (bool, int) {
+	key := level.String() + "\x00" + message
+	now := s.now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now != s.second {
+		dropped := map[string]int{}
+		for k, n := range s.counts {
+			if n > s.perSecond {
+				dropped[k] = n - s.perSecond
+			}
+		}
+		s.second, s.counts, s.dropped = now, map[string]int{}, dropped
+	}
+
+	s.counts[key]++
+	if s.counts[key] > s.perSecond {
+		return false, 0
+	}
+
+	dropped := s.dropped[key]
+	delete(s.dropped, key)
+
+	return true, dropped
+}
@@ -0,0 +1,121 @@
+package jsonlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	s := newSampler(2)
+	s.now = func() time.Time { return now }
+
+	type entry struct {
+		level       Level
+		message     string
+		advance     time.Duration
+		wantAllowed bool
+		wantDropped int
+	}
+
+	entries := []entry{
+		{LevelInfo, "a", 0, true, 0},
+		{LevelInfo, "a", 0, true, 0},
+		{LevelInfo, "a", 0, false, 0},
+		{LevelInfo, "a", 0, false, 0},
+		{LevelWarn, "a", 0, true, 0},
+		{LevelInfo, "b", 0, true, 0},
+		{LevelInfo, "a", time.Second, true, 2},
+		{LevelInfo, "a", 0, true, 0},
+		{LevelInfo, "a", 0, false, 0},
+		{LevelInfo, "b", 0, true, 0},
+		{LevelInfo, "a", 2 * time.Second, true, 1},
+		{LevelInfo, "a", time.Second, true, 0},
+	}
+
+	for i, e := range entries {
+		now = now.Add(e.advance)
+
+		allowed, dropped := s.allow(e.level, e.message)
+		if allowed != e.wantAllowed || dropped != e.wantDropped {
+			t.Errorf("entry %d (%s %q): allow = %t, %d; want %t, %d", i, e.level, e.message, allowed, dropped, e.wantAllowed, e.wantDropped)
+		}
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := New(&buf, LevelInfo, WithSampling(1))
+	logger.core.sampler.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	logger.PrintInfo("busy", nil)
+	logger.PrintInfo("busy", nil)
+	logger.Unsampled().PrintInfo("busy", nil)
+	logger.print(LevelFatal, "busy", nil)
+	logger.print(LevelFatal, "busy", nil)
+
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("wrote %d entries; want 4:\n%s", lines, buf.String())
+	}
+}
+
+func TestLoggerFatalBelowLevel(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := New(&buf, LevelOff)
+
+	logger.PrintError(errors.New("ignored"), nil)
+	logger.print(LevelFatal, "exiting", nil)
+
+	if got := buf.String(); strings.Contains(got, "ignored") || !strings.Contains(got, "exiting") {
+		t.Errorf("got %q; want only the fatal entry", got)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+		trace      string
+		want       string
+	}{
+		{
+			name: "no properties",
+			want: "2024-01-02T03:04:05Z INFO starting",
+		},
+		{
+			name:       "sorted keys",
+			properties: map[string]any{"port": 4000, "env": "development", "db": true},
+			want:       "2024-01-02T03:04:05Z INFO starting db=true env=development port=4000",
+		},
+		{
+			name:       "quoted values",
+			properties: map[string]any{"empty": "", "space": "a b", "equals": "a=b", "quote": `a"b`, "newline": "a\nb", "tab": "a\tb"},
+			want:       `2024-01-02T03:04:05Z INFO starting empty="" equals="a=b" newline="a\nb" quote="a\"b" space="a b" tab="a\tb"`,
+		},
+		{
+			name:       "errors and stringers",
+			properties: map[string]any{"error": errors.New("no such host"), "duration": 1500 * time.Millisecond, "nil": nil},
+			want:       `2024-01-02T03:04:05Z INFO starting duration=1.5s error="no such host" nil=<nil>`,
+		},
+		{
+			name:  "trace",
+			trace: "goroutine 1:\nmain.main()",
+			want:  `2024-01-02T03:04:05Z INFO starting trace="goroutine 1:\nmain.main()"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(formatText("2024-01-02T03:04:05Z", "INFO", "starting", tt.properties, tt.trace))
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
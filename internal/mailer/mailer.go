@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	textTemplate "text/template"
+	"time"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer delivers a templated message. Implementations are expected to be
+// safe for concurrent use because mails are sent from background goroutines.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+	Date      time.Time
+}
+
+// Render executes the subject, plainBody and htmlBody blocks of an embedded
+// template.
+func Render(sender, recipient, templateFile string, data any) (*Message, error) {
+	tmpl, err := textTemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:      sender,
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: strings.TrimSpace(plainBody.String()),
+		HTMLBody:  strings.TrimSpace(htmlBody.String()),
+		Date:      time.Now().UTC(),
+	}, nil
+}
+
+func (m *Message) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From, m.To, m.Subject, m.Date.Format(time.RFC1123Z), m.PlainBody)
+	return err
+}
+
+// LogMailer writes every message to an io.Writer instead of delivering it.
+type LogMailer struct {
+	out    io.Writer
+	sender string
+	mu     sync.Mutex
+}
+
+func NewLogMailer(out io.Writer, sender string) *LogMailer {
+	return &LogMailer{
+		out:    out,
+		sender: sender,
+	}
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return msg.writeTo(m.out)
+}
+
+// FileMailer stores every message as an .eml file in a directory so that it
+// can be opened with a regular mail client during local development.
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+func NewFileMailer(dir, sender string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{
+		dir:    dir,
+		sender: sender,
+	}, nil
+}
+
+func (m *FileMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", msg.Date.UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(recipient))
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return msg.writeTo(f)
+}
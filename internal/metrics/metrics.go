@@ -0,0 +1,365 @@
+// Package metrics is a small collector for counters, gauges and histograms
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.collectors = append(reg.collectors, c)
+}
+
+// Write writes every registered metric in registration order.
+func (reg *Registry) Write(w io.Writer) error {
+	reg.mu.Lock()
+	collectors := append([]collector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+}
+
+// series renders the name and label set of a sample, with extra appended
+// after the declared labels.
+func (d *desc) series(suffix string, values []string, extra ...string) string {
+	var b strings.Builder
+	b.WriteString(d.name)
+	b.WriteString(suffix)
+
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escape(v)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+
+	if len(pairs) > 0 {
+		b.WriteString("{")
+		b.WriteString(strings.Join(pairs, ","))
+		b.WriteString("}")
+	}
+
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(v string) string {
+	return escaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// vec keeps one value per label set, created on first use.
+type vec[T any] struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*T
+	keys   map[string][]string
+	init   func() *T
+}
+
+func newVec[T any](d desc, init func() *T) *vec[T] {
+	return &vec[T]{desc: d, values: map[string]*T{}, keys: map[string][]string{}, init: init}
+}
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := labelKey(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	t, ok := v.values[key]
+	if !ok {
+		t = v.init()
+		v.values[key] = t
+		v.keys[key] = append([]string(nil), values...)
+	}
+
+	return t
+}
+
+// each calls fn for every label set in a stable order.
+func (v *vec[T]) each(fn func(values []string, t *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.Lock()
+		t, values := v.values[key], v.keys[key]
+		v.mu.Unlock()
+
+		fn(values, t)
+	}
+}
+
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (val *value) add(delta float64) {
+	val.mu.Lock()
+	val.v += delta
+	val.mu.Unlock()
+}
+
+func (val *value) set(v float64) {
+	val.mu.Lock()
+	val.v = v
+	val.mu.Unlock()
+}
+
+func (val *value) load() float64 {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	return val.v
+}
+
+type CounterVec struct {
+	*vec[value]
+}
+
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(desc{name: name, help: help, typ: "counter", labels: labels}, func() *value { return &value{} })}
+	reg.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.get(labelValues).add(1)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.get(labelValues).add(delta)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.each(func(values []string, val *value) {
+		fmt.Fprintf(w, "%s %s\n", c.series("", values), formatFloat(val.load()))
+	})
+}
+
+type GaugeVec struct {
+	*vec[value]
+}
+
+func (reg *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(desc{name: name, help: help, typ: "gauge", labels: labels}, func() *value { return &value{} })}
+	reg.register(g)
+	return g
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.get(labelValues).add(1)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.get(labelValues).add(-1)
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.get(labelValues).set(v)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.header(w)
+	g.each(func(values []string, val *value) {
+		fmt.Fprintf(w, "%s %s\n", g.series("", values), formatFloat(val.load()))
+	})
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type HistogramVec struct {
+	*vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds, which must
+// be sorted in increasing order. A +Inf bucket is always added.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec: newVec(desc{name: name, help: help, typ: "histogram", labels: labels}, func() *histogram {
+			return &histogram{counts: make([]uint64, len(buckets))}
+		}),
+		buckets: buckets,
+	}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	hist := h.get(labelValues)
+
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.each(func(values []string, hist *histogram) {
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		count, sum := hist.count, hist.sum
+		hist.mu.Unlock()
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.series("_bucket", values, "le", formatFloat(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.series("_bucket", values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s %s\n", h.series("_sum", values), formatFloat(sum))
+		fmt.Fprintf(w, "%s %d\n", h.series("_count", values), count)
+	})
+}
+
+// funcMetric reports a value read at scrape time.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+func (reg *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc: desc{name: name, help: help, typ: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc registers a counter maintained elsewhere, such as the
+// cumulative counts kept by database/sql.
+func (reg *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc: desc{name: name, help: help, typ: "counter"}, fn: fn})
+}
+
+// runtimeCollector reads the memory statistics once per scrape, since doing
+// so briefly stops the world.
+type runtimeCollector struct{}
+
+func (runtimeCollector) write(w *bufio.Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	metrics := []struct {
+		name, help, typ string
+		value           float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_threads", "Number of OS threads created.", "gauge", float64(threadCount())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge", float64(m.Alloc)},
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(m.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from the system.", "gauge", float64(m.Sys)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(m.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(m.HeapObjects)},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when the next garbage collection will take place.", "gauge", float64(m.NextGC)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(m.NumGC)},
+		{"go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter", float64(m.PauseTotalNs) / 1e9},
+	}
+
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", metric.name, metric.help, metric.name, metric.typ, metric.name, formatFloat(metric.value))
+	}
+}
+
+func threadCount() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
+
+// RegisterRuntime adds the Go runtime statistics: goroutines, memory and GC.
+func (reg *Registry) RegisterRuntime() {
+	reg.register(runtimeCollector{})
+}
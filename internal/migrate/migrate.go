@@ -0,0 +1,337 @@
+// Package migrate applies versioned SQL migrations. The migrations are
+// NNNNNN_name.up.sql / NNNNNN_name.down.sql pairs, and the applied version is
+// kept in the same schema_migrations table golang-migrate uses so that
+// databases set up with that tool carry on where they left off.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("database schema is dirty and must be repaired by hand")
+	ErrUnknownVersion = errors.New("unknown schema version")
+)
+
+// lockID is the advisory lock key held while migrating, so that instances
+// starting side by side do not apply the same migration twice.
+const lockID int64 = 7306417254312
+
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version int64
+	Dirty   bool
+	Latest  int64
+	Pending []Migration
+}
+
+// Behind reports whether the database is missing migrations that the binary
+// knows about.
+func (s *Status) Behind() bool {
+	return s.Dirty || s.Version < s.Latest
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New reads the migrations at the root of fsys.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+
+		script, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = mig
+		}
+
+		if mig.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d: conflicting names %s and %s", version, mig.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			mig.Up = string(script)
+		} else {
+			mig.Down = string(script)
+		}
+	}
+
+	m := &Migrator{db: db}
+
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up script", mig.Version, mig.Name)
+		}
+		m.migrations = append(m.migrations, *mig)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Latest is the version of the newest embedded migration.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Latest: m.Latest()}
+
+	if exists {
+		status.Version, status.Dirty, err = readVersion(ctx, m.db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > status.Version {
+			status.Pending = append(status.Pending, mig)
+		}
+	}
+
+	return status, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.migrate(ctx, func(int64) (int64, error) {
+		return m.Latest(), nil
+	})
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) ([]Migration, error) {
+	return m.migrate(ctx, m.previous)
+}
+
+// previous is the version before current, which Down migrates to.
+func (m *Migrator) previous(current int64) (int64, error) {
+	i := m.index(current)
+	switch {
+	case current == 0:
+		return 0, nil
+	case i < 0:
+		return 0, fmt.Errorf("%w %d", ErrUnknownVersion, current)
+	case i == 0:
+		return 0, nil
+	default:
+		return m.migrations[i-1].Version, nil
+	}
+}
+
+// To migrates up or down to exactly version, where 0 rolls back everything.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && m.index(version) < 0 {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	return m.migrate(ctx, func(int64) (int64, error) {
+		return version, nil
+	})
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// migrate holds the advisory lock on a single connection while it moves the
+// schema from its current version to the one chosen by target. Each
+// migration runs in its own transaction together with the version update,
+// so a failure leaves the schema at the last version that succeeded. The
+// migrations applied are returned in the order they ran.
+func (m *Migrator) migrate(ctx context.Context, target func(current int64) (int64, error)) ([]Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version bigint NOT NULL PRIMARY KEY,
+        dirty boolean NOT NULL
+    )`)
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := readVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dirty {
+		return nil, fmt.Errorf("%w (version %d)", ErrDirty, current)
+	}
+
+	goal, err := target(current)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := m.plan(current, goal)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+
+	for _, step := range steps {
+		if err = runScript(ctx, conn, step.script, step.version); err != nil {
+			return applied, fmt.Errorf("migration %d_%s %s: %w", step.Version, step.Name, step.direction, err)
+		}
+
+		applied = append(applied, step.Migration)
+	}
+
+	return applied, nil
+}
+
+// step is one script to run and the version it leaves the schema at.
+type step struct {
+	Migration
+	direction string
+	script    string
+	version   int64
+}
+
+// plan lists the scripts which take the schema from current to goal. A down
+// migration without a down script fails the whole plan before anything runs.
+func (m *Migrator) plan(current, goal int64) ([]step, error) {
+	var steps []step
+
+	if goal >= current {
+		for _, mig := range m.migrations {
+			if mig.Version <= current || mig.Version > goal {
+				continue
+			}
+			steps = append(steps, step{Migration: mig, direction: "up", script: mig.Up, version: mig.Version})
+		}
+
+		return steps, nil
+	}
+
+	if m.index(current) < 0 {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, current)
+	}
+
+	for i := m.index(current); i >= 0 && m.migrations[i].Version > goal; i-- {
+		mig := m.migrations[i]
+
+		if mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing down script", mig.Version, mig.Name)
+		}
+
+		var previous int64
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+
+		steps = append(steps, step{Migration: mig, direction: "down", script: mig.Down, version: previous})
+	}
+
+	return steps, nil
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readVersion(ctx context.Context, q rowQueryer) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// runScript runs script and records version as the one now applied, with 0
+// meaning none.
+func runScript(ctx context.Context, conn *sql.Conn, script string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
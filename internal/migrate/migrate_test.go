@@ -0,0 +1,220 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func migrationFS(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys[name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		want     []int64
+		wantDown []bool
+		wantErr  string
+	}{
+		{
+			name:     "sorted by version",
+			files:    []string{"000002_b.up.sql", "000002_b.down.sql", "000010_c.up.sql", "000001_a.up.sql", "000001_a.down.sql"},
+			want:     []int64{1, 2, 10},
+			wantDown: []bool{true, true, false},
+		},
+		{
+			name:     "other files ignored",
+			files:    []string{"000001_a.up.sql", "migrations.go", "README.md", "000002_b.sideways.sql"},
+			want:     []int64{1},
+			wantDown: []bool{false},
+		},
+		{
+			name:  "empty",
+			files: nil,
+		},
+		{
+			name:    "conflicting names",
+			files:   []string{"000001_a.up.sql", "000001_b.down.sql"},
+			wantErr: "conflicting names",
+		},
+		{
+			name:    "missing up script",
+			files:   []string{"000001_a.up.sql", "000002_b.down.sql"},
+			wantErr: "missing up script",
+		},
+		{
+			name:    "version zero",
+			files:   []string{"000000_a.up.sql"},
+			wantErr: "invalid version",
+		},
+		{
+			name:    "version overflow",
+			files:   []string{"99999999999999999999_a.up.sql"},
+			wantErr: "invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(nil, migrationFS(tt.files...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int64
+			var gotDown []bool
+			for _, mig := range m.migrations {
+				got = append(got, mig.Version)
+				gotDown = append(gotDown, mig.Down != "")
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versions = %v; want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(gotDown, tt.wantDown) {
+				t.Errorf("down scripts = %v; want %v", gotDown, tt.wantDown)
+			}
+
+			var latest int64
+			if len(tt.want) > 0 {
+				latest = tt.want[len(tt.want)-1]
+			}
+			if m.Latest() != latest {
+				t.Errorf("Latest() = %d; want %d", m.Latest(), latest)
+			}
+		})
+	}
+}
+
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	m, err := New(nil, migrationFS(
+		"000001_a.up.sql", "000001_a.down.sql",
+		"000002_b.up.sql", "000002_b.down.sql",
+		"000005_c.up.sql", "000005_c.down.sql",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestPrevious(t *testing.T) {
+	m := newTestMigrator(t)
+
+	tests := []struct {
+		current int64
+		want    int64
+		wantErr error
+	}{
+		{0, 0, nil},
+		{1, 0, nil},
+		{2, 1, nil},
+		{5, 2, nil},
+		{3, 0, ErrUnknownVersion},
+	}
+
+	for _, tt := range tests {
+		got, err := m.previous(tt.current)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("previous(%d) returned error %v; want %v", tt.current, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("previous(%d) = %d; want %d", tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := newTestMigrator(t)
+
+	// Each step is written as direction, migration version and the version
+	// it leaves the schema at.
+	tests := []struct {
+		name    string
+		current int64
+		goal    int64
+		want    []string
+		wantErr error
+	}{
+		{"up from empty", 0, 5, []string{"up 1 1", "up 2 2", "up 5 5"}, nil},
+		{"up partway", 0, 2, []string{"up 1 1", "up 2 2"}, nil},
+		{"up from middle", 2, 5, []string{"up 5 5"}, nil},
+		{"already latest", 5, 5, nil, nil},
+		{"down one", 5, 2, []string{"down 5 2"}, nil},
+		{"down to first", 5, 1, []string{"down 5 2", "down 2 1"}, nil},
+		{"down to nothing", 2, 0, []string{"down 2 1", "down 1 0"}, nil},
+		{"down from unknown", 3, 1, nil, ErrUnknownVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := m.plan(tt.current, tt.goal)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, s := range steps {
+				if s.direction == "up" && s.script != s.Up || s.direction == "down" && s.script != s.Down {
+					t.Errorf("step %d %s runs the wrong script", s.Version, s.direction)
+				}
+				got = append(got, fmt.Sprintf("%s %d %d", s.direction, s.Version, s.version))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanMissingDownScript(t *testing.T) {
+	m, err := New(nil, migrationFS("000001_a.up.sql", "000001_a.down.sql", "000002_b.up.sql", "000003_c.up.sql", "000003_c.down.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.plan(3, 2); err != nil {
+		t.Errorf("plan(3, 2) returned error %v", err)
+	}
+
+	// Nothing is planned, so 3 is not rolled back before 2 fails.
+	steps, err := m.plan(3, 0)
+	if err == nil || !strings.Contains(err.Error(), "missing down script") {
+		t.Errorf("plan(3, 0) returned error %v; want missing down script", err)
+	}
+	if steps != nil {
+		t.Errorf("plan(3, 0) = %v; want no steps", steps)
+	}
+}
+
+func TestToUnknownVersion(t *testing.T) {
+	m := newTestMigrator(t)
+
+	_, err := m.To(context.Background(), 3)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("To(3) returned error %v; want %v", err, ErrUnknownVersion)
+	}
+}
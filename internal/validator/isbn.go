@@ -0,0 +1,98 @@
+package validator
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NormalizeISBN strips the separators commonly used when writing ISBNs and
+// upper-cases the ISBN-10 check digit.
+func NormalizeISBN(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
+}
+
+func IsISBN10(isbn string) bool {
+	if len(isbn) != 10 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 10; i++ {
+		c := isbn[i]
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+
+	return sum%11 == 0
+}
+
+func IsISBN13(isbn string) bool {
+	if len(isbn) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		c := isbn[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// ISBN10To13 converts a valid ISBN-10 into its 978-prefixed ISBN-13 form.
+func ISBN10To13(isbn string) string {
+	if !IsISBN10(isbn) {
+		return ""
+	}
+
+	body := "978" + isbn[:9]
+
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(body[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return body + strconv.Itoa((10-sum%10)%10)
+}
+
+// ISBN13To10 converts a valid ISBN-13 into an ISBN-10. Only the 978 prefix
+// has an ISBN-10 equivalent; an empty string is returned otherwise.
+func ISBN13To10(isbn string) string {
+	if !IsISBN13(isbn) || !strings.HasPrefix(isbn, "978") {
+		return ""
+	}
+
+	body := isbn[3:12]
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+
+	return body + strconv.Itoa(check)
+}
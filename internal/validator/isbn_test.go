@@ -0,0 +1,108 @@
+package validator
+
+import "testing"
+
+func TestIsISBN10(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{"0306406152", true},
+		{"080442957X", true},
+		{"0198534531", true},
+		{"0306406153", false},
+		{"080442957x", false},
+		{"X306406152", false},
+		{"030640615", false},
+		{"03064061522", false},
+		{"0-306-40615-2", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsISBN10(tt.isbn); got != tt.want {
+			t.Errorf("IsISBN10(%q) = %t; want %t", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestIsISBN13(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{"9780306406157", true},
+		{"9780804429573", true},
+		{"9791090636071", true},
+		{"9780306406158", false},
+		{"978030640615X", false},
+		{"978030640615", false},
+		{"97803064061577", false},
+		{"978-0-306-40615-7", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsISBN13(tt.isbn); got != tt.want {
+			t.Errorf("IsISBN13(%q) = %t; want %t", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestISBN10To13(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want string
+	}{
+		{"0306406152", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"0198534531", "9780198534532"},
+		{"0306406153", ""},
+		{"9780306406157", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ISBN10To13(tt.isbn); got != tt.want {
+			t.Errorf("ISBN10To13(%q) = %q; want %q", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestISBN13To10(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want string
+	}{
+		{"9780306406157", "0306406152"},
+		{"9780804429573", "080442957X"},
+		{"9780198534532", "0198534531"},
+		{"9791090636071", ""},
+		{"9780306406158", ""},
+		{"0306406152", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ISBN13To10(tt.isbn); got != tt.want {
+			t.Errorf("ISBN13To10(%q) = %q; want %q", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want string
+	}{
+		{"0-306-40615-2", "0306406152"},
+		{" 978 0 306 40615 7 ", "9780306406157"},
+		{"0-8044-2957-x", "080442957X"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeISBN(tt.isbn); got != tt.want {
+			t.Errorf("NormalizeISBN(%q) = %q; want %q", tt.isbn, got, tt.want)
+		}
+	}
+}
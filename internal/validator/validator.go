@@ -3,6 +3,7 @@ package validator
 import "regexp"
 
 var (
+	SlugRX  = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
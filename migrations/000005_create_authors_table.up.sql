@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    bio text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS authors_name_unique_idx ON authors (lower(name));
+CREATE INDEX IF NOT EXISTS authors_name_idx ON authors USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    author_id bigint NOT NULL REFERENCES authors ON DELETE CASCADE,
+    role text NOT NULL DEFAULT 'author',
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id, role),
+    CONSTRAINT book_authors_role_check CHECK (role IN ('author', 'translator', 'editor'))
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id);
+
+INSERT INTO authors (name)
+SELECT DISTINCT ON (lower(trim(author))) trim(author)
+FROM books
+WHERE trim(author) <> ''
+ORDER BY lower(trim(author)), trim(author)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO book_authors (book_id, author_id, role)
+SELECT books.id, authors.id, 'author'
+FROM books
+INNER JOIN authors ON lower(authors.name) = lower(trim(books.author))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS books_publisher_id_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS publisher_id;
+DROP TABLE IF EXISTS publishers;
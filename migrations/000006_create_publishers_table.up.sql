@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS publishers (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    website text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS publishers_name_unique_idx ON publishers (lower(name));
+CREATE INDEX IF NOT EXISTS publishers_name_idx ON publishers USING GIN (to_tsvector('simple', name));
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher_id bigint REFERENCES publishers ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS books_publisher_id_idx ON books (publisher_id);
+
+INSERT INTO publishers (name)
+SELECT DISTINCT ON (lower(trim(publisher))) trim(publisher)
+FROM books
+WHERE trim(publisher) <> ''
+ORDER BY lower(trim(publisher)), trim(publisher)
+ON CONFLICT DO NOTHING;
+
+UPDATE books SET publisher_id = publishers.id
+FROM publishers
+WHERE lower(publishers.name) = lower(trim(books.publisher))
+AND books.publisher_id IS NULL;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS book_types (
+    id serial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    parent_id integer REFERENCES book_types ON DELETE RESTRICT,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT book_types_parent_check CHECK (parent_id IS NULL OR parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS book_types_parent_id_idx ON book_types (parent_id);
+
+INSERT INTO book_types (id, slug, name) VALUES
+    (1, 'islamic', 'Islamic'),
+    (2, 'comparative-religion', 'Comparative Religion')
+ON CONFLICT DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('book_types', 'id'), (SELECT max(id) FROM book_types));
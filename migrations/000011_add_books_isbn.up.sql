@@ -0,0 +1,5 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn10 text;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn13 text;
+
+ALTER TABLE books ADD CONSTRAINT books_isbn10_key UNIQUE (isbn10);
+ALTER TABLE books ADD CONSTRAINT books_isbn13_key UNIQUE (isbn13);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS books_name_trgm_idx;
@@ -0,0 +1,3 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS books_name_trgm_idx ON books USING GIN (name gin_trgm_ops);
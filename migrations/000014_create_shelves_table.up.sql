@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS shelves (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    public_slug text UNIQUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS shelves_user_name_unique_idx ON shelves (user_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS shelf_books (
+    shelf_id bigint NOT NULL REFERENCES shelves ON DELETE CASCADE,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (shelf_id, book_id)
+);
+
+CREATE INDEX IF NOT EXISTS shelf_books_book_id_idx ON shelf_books (book_id);
+CREATE INDEX IF NOT EXISTS shelf_books_position_idx ON shelf_books (shelf_id, position);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reading_progress;
+ALTER TABLE books DROP CONSTRAINT IF EXISTS page_count_check;
+ALTER TABLE books DROP COLUMN IF EXISTS page_count;
@@ -0,0 +1,21 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD CONSTRAINT page_count_check CHECK (page_count >= 0);
+
+CREATE TABLE IF NOT EXISTS reading_progress (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    status text NOT NULL,
+    current_page integer NOT NULL DEFAULT 0,
+    started_on date,
+    finished_on date,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (user_id, book_id),
+    CONSTRAINT reading_progress_status_check CHECK (status IN ('want_to_read', 'reading', 'finished', 'abandoned')),
+    CONSTRAINT reading_progress_current_page_check CHECK (current_page >= 0),
+    CONSTRAINT reading_progress_dates_check CHECK (finished_on IS NULL OR started_on IS NULL OR finished_on >= started_on)
+);
+
+CREATE INDEX IF NOT EXISTS reading_progress_book_id_idx ON reading_progress (book_id);
+CREATE INDEX IF NOT EXISTS reading_progress_finished_on_idx ON reading_progress (user_id, finished_on);
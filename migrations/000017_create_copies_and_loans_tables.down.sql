@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'loans:write';
+DROP TABLE IF EXISTS loans;
+DROP TABLE IF EXISTS copies;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS copies (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    book_id bigint NOT NULL REFERENCES books ON DELETE RESTRICT,
+    barcode text NOT NULL,
+    condition text NOT NULL DEFAULT 'good',
+    location text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT copies_barcode_key UNIQUE (barcode),
+    CONSTRAINT copies_condition_check CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged'))
+);
+
+CREATE INDEX IF NOT EXISTS copies_book_id_idx ON copies (book_id);
+
+CREATE TABLE IF NOT EXISTS loans (
+    id bigserial PRIMARY KEY,
+    copy_id bigint NOT NULL REFERENCES copies ON DELETE RESTRICT,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    checked_out_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    due_at timestamp(0) with time zone NOT NULL,
+    returned_at timestamp(0) with time zone,
+    renewals integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- At most one loan per copy may be open at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS loans_active_copy_idx ON loans (copy_id) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS loans_due_at_idx ON loans (due_at) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS loans_user_id_idx ON loans (user_id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('loans:write')
+ON CONFLICT DO NOTHING;
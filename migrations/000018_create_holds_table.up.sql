@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS holds (
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'waiting',
+    copy_id bigint REFERENCES copies ON DELETE RESTRICT,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ready_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT holds_status_check CHECK (status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+    CONSTRAINT holds_ready_check CHECK (status <> 'ready' OR (copy_id IS NOT NULL AND expires_at IS NOT NULL))
+);
+
+-- A borrower queues at most once per book, and a copy is set aside for at
+-- most one hold at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_user_book_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
+CREATE UNIQUE INDEX IF NOT EXISTS holds_ready_copy_idx ON holds (copy_id) WHERE status = 'ready';
+CREATE INDEX IF NOT EXISTS holds_queue_idx ON holds (book_id, created_at, id) WHERE status = 'waiting';
+CREATE INDEX IF NOT EXISTS holds_expires_at_idx ON holds (expires_at) WHERE status = 'ready';
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id);